@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	client2 "aliyun-images-syncer/pkg/client"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	planPair string
+	planJSON bool
+)
+
+// PlanCmd 输出同步计划，不向从镜像仓库写入任何数据
+var PlanCmd = &cobra.Command{
+	Use:   "plan [namespace[/repo[:tag]]]",
+	Short: "Print what a sync would transfer without pushing anything",
+	Long: `Print what a sync would transfer without pushing anything.
+It lists and diffs the registries, resolves manifests and platforms and checks which blobs already exist in the destination.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var target *client2.SyncTarget
+		if len(args) == 1 {
+			var err error
+			if target, err = client2.ParseSyncTarget(planPair, args[0]); err != nil {
+				return err
+			}
+		}
+		_client, err := newClient()
+		if err != nil {
+			return err
+		}
+		defer _client.Close()
+		_client.DryRun = true
+
+		var result *client2.RunResult
+		if target != nil {
+			if err := _client.CheckTarget(target); err != nil {
+				return err
+			}
+			result = _client.RunTarget(target)
+		} else {
+			result = _client.Run()
+		}
+
+		if planJSON {
+			data, err := json.MarshalIndent(result, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+		} else if err := client2.WritePlan(os.Stdout, result); err != nil {
+			return err
+		}
+		return result.Err()
+	},
+}
+
+func init() {
+	PlanCmd.Flags().StringVar(&planPair, "pair", "", "只输出指定主从的同步计划，需要同时指定namespace")
+	PlanCmd.Flags().BoolVar(&planJSON, "json", false, "以json格式输出同步计划")
+	RootCmd.AddCommand(PlanCmd)
+}
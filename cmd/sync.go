@@ -2,15 +2,20 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"aliyun-images-syncer/pkg/client"
+	"aliyun-images-syncer/pkg/lease"
+	"aliyun-images-syncer/pkg/state"
+	"aliyun-images-syncer/pkg/sync"
 	"aliyun-images-syncer/util/svcutil"
 
 	client2 "aliyun-images-syncer/pkg/client"
@@ -18,17 +23,39 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/golang/glog"
 	"github.com/rs/zerolog/log"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
-const KeyDep = "key:dep"
+const (
+	KeyDep  = "key:dep"
+	KeyJobs = "key:jobs"
+)
 
 var (
 	token, logPath, repoNamespaceName, instanceIdMaster, instanceIdSlave, accountMaster, passwordMaster, accountSlave, passwordSlave, accessKeyIdMaster, accessKeySecretMaster, endpointMaster, accessKeyIdSlave, accessKeySecretSlave, endpointSlave string
 	publicNetworkMaster, publicNetworkSlave                                                                                                                                                                                                           string
 	procNum, retries, polling                                                                                                                                                                                                                         int
+	registryConnections, taskTimeout, taskBandwidth, blobWorkers                                                                                                                                                                                      int
 	mailHost, mailUserName, mailAuthCode, mailTo                                                                                                                                                                                                      string
 	repoNamespaceNames                                                                                                                                                                                                                                []string
+	configPath                                                                                                                                                                                                                                        string
+	namespaceMapping                                                                                                                                                                                                                                  map[string]string
+	qps                                                                                                                                                                                                                                               float64
+	reportDir                                                                                                                                                                                                                                         string
+	webhookToken                                                                                                                                                                                                                                      string
+	leaseBackend, leaseFile, leaseName, leaseNamespace                                                                                                                                                                                                string
+	leaseDuration                                                                                                                                                                                                                                     int
+	stateFile                                                                                                                                                                                                                                         string
+	stateHistory                                                                                                                                                                                                                                      int
+	dryRun                                                                                                                                                                                                                                            bool
+	syncReferrers                                                                                                                                                                                                                                     bool
+	referrerTypes                                                                                                                                                                                                                                     []string
+	artifactTypes                                                                                                                                                                                                                                     []string
+	excludeArtifactTypes                                                                                                                                                                                                                              []string
+	osFilterList                                                                                                                                                                                                                                      []string
+	archFilterList                                                                                                                                                                                                                                    []string
+	verify                                                                                                                                                                                                                                            bool
 )
 
 // RootCmd describes "image-syncer" command
@@ -38,30 +65,44 @@ var RootCmd = &cobra.Command{
 	Short:   "A docker registry image real time synchronization tool！by fermi",
 	Long:    `A Fast and Flexible docker registry image real time synchronization tool implement by Go.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// dig
-		dep := client2.DIDependency()
-		// work starts here
-		_client, err := client.CreateClient(
-			&accessKeyIdMaster, &accessKeySecretMaster, &endpointMaster, &accountMaster, &passwordMaster,
-			&accessKeyIdSlave, &accessKeySecretSlave, &endpointSlave, &accountSlave, &passwordSlave,
-			&repoNamespaceName, &instanceIdMaster, &instanceIdSlave,
-			&publicNetworkMaster, &publicNetworkSlave,
-			mailHost, mailUserName, mailAuthCode, mailTo,
-			logPath, repoNamespaceNames, dep,
-		)
+		// webhook的token会配置到阿里云并且出现在url中，不能和管理接口共用
+		if webhookToken != "" && webhookToken == token {
+			return fmt.Errorf("--webhookToken should be different from --token")
+		}
+		_client, err := newClient()
 		if err != nil {
-			return fmt.Errorf("init sync client error: %v", err)
+			return err
 		}
+		defer _client.Close()
 
-		pollingTime := time.Duration(polling) * time.Second
+		// 多副本部署时只有leader执行同步，退出时释放锁
+		_client.Leader, err = newElector(_client.Logger)
+		if err != nil {
+			return err
+		}
+		if _client.Leader != nil {
+			electorCtx, stopElector := context.WithCancel(context.Background())
+			electorDone := make(chan struct{})
+			go func() {
+				_client.Leader.Run(electorCtx)
+				close(electorDone)
+			}()
+			defer func() {
+				stopElector()
+				<-electorDone
+			}()
+		}
+
+		// 每组主从可以单独配置轮询间隔，ticker 使用最短的间隔，每次只同步到期的主从
+		pollingTime := _client.PollingInterval()
 		log.Debug().Msgf("轮询间隔pollingTime: %v", pollingTime)
 
-		go Http(_client, token)
+		go Http(_client, token, webhookToken)
 
 		//  优雅轮询并且启动健康检查，并且在接收到失败信号好，结束程序
 		svcutil.NeverStopByTicker(":8000", time.NewTicker(pollingTime), func() {
 			log.Info().Msg("Normal operation, bro～")
-			_client.Run()
+			_client.RunDue()
 		})
 
 		log.Log().Msg("images-sync NeverStopByTicker shutting down :)")
@@ -71,7 +112,152 @@ var RootCmd = &cobra.Command{
 	},
 }
 
-func Http(client *client2.Client, token string) {
+// newClient 根据配置文件或者命令行参数初始化同步client
+func newClient() (*client2.Client, error) {
+	// 同步规则，优先使用配置文件
+	syncerConfig, err := loadSyncerConfig()
+	if err != nil {
+		return nil, err
+	}
+	// dig
+	dep := client2.DIDependency()
+	// work starts here
+	_client, err := client.CreateClient(
+		syncerConfig, time.Duration(polling)*time.Second,
+		mailHost, mailUserName, mailAuthCode, mailTo,
+		logPath, reportDir, dep,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("init sync client error: %v", err)
+	}
+	_client.DryRun = dryRun
+	_client.Verify = verify || syncerConfig.Verify
+	// 并发设置，配置文件优先，没有填写的使用命令行参数
+	concurrency := client2.Concurrency{
+		Workers:             procNum,
+		Retries:             retries,
+		RegistryConnections: registryConnections,
+		TaskTimeout:         taskTimeout,
+		BlobWorkers:         blobWorkers,
+		TaskBandwidth:       taskBandwidth,
+	}
+	if syncerConfig.Concurrency != nil {
+		concurrency = concurrency.Override(*syncerConfig.Concurrency)
+	}
+	if err := _client.SetConcurrency(concurrency); err != nil {
+		return nil, fmt.Errorf("invalid concurrency:\n%v", err)
+	}
+	// 镜像的签名、SBOM和attestation，配置文件优先
+	if syncerConfig.Referrers != nil {
+		_client.Referrers = syncerConfig.Referrers
+	} else if syncReferrers {
+		_client.Referrers = &sync.ReferrersOptions{ArtifactTypes: referrerTypes}
+	}
+	// Helm chart等非镜像制品，配置文件优先
+	if syncerConfig.Artifacts != nil {
+		_client.Artifacts = syncerConfig.Artifacts
+	} else if len(artifactTypes) != 0 || len(excludeArtifactTypes) != 0 {
+		_client.Artifacts = &sync.ArtifactOptions{Include: artifactTypes, Exclude: excludeArtifactTypes}
+	}
+	// 每个镜像的同步状态，重启后跳过已经校验过的镜像并且恢复中断的同步
+	if stateFile != "" {
+		if _client.State, err = state.NewBoltStore(stateFile, stateHistory); err != nil {
+			return nil, err
+		}
+	}
+	return _client, nil
+}
+
+// newElector 根据 --lease 创建选主，没有配置时返回nil，单副本直接执行同步
+func newElector(logger *logrus.Logger) (*lease.Elector, error) {
+	identity, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	duration := time.Duration(leaseDuration) * time.Second
+	if duration <= 0 {
+		return nil, fmt.Errorf("invalid flags: leaseDuration should be positive")
+	}
+
+	var l lease.Lease
+	switch leaseBackend {
+	case "":
+		return nil, nil
+	case "file":
+		if leaseFile == "" {
+			return nil, fmt.Errorf("invalid flags: leaseFile should not be empty when lease is file")
+		}
+		l = lease.NewFileLease(leaseFile, identity)
+	case "kubernetes":
+		leaseClient, err := lease.NewInClusterLeaseClient()
+		if err != nil {
+			return nil, err
+		}
+		namespace := leaseNamespace
+		if namespace == "" {
+			namespace = lease.InClusterNamespace()
+		}
+		l = lease.NewKubernetesLease(leaseClient, namespace, leaseName, identity, duration)
+	default:
+		return nil, fmt.Errorf("invalid flags: unknown lease %q, should be file or kubernetes", leaseBackend)
+	}
+	// 每个过期时间内续约三次
+	return lease.NewElector(l, duration/3, logger), nil
+}
+
+// loadSyncerConfig 指定了 --config 时从文件加载同步规则，否则使用命令行参数
+func loadSyncerConfig() (*client2.SyncerConfig, error) {
+	if configPath != "" {
+		return client2.LoadSyncerConfig(configPath)
+	}
+
+	syncerConfig := &client2.SyncerConfig{
+		Master: client2.Registry{
+			AccessKeyId:     accessKeyIdMaster,
+			AccessKeySecret: accessKeySecretMaster,
+			Endpoint:        endpointMaster,
+			InstanceId:      instanceIdMaster,
+			Network:         publicNetworkMaster,
+			Account:         accountMaster,
+			Password:        passwordMaster,
+			QPS:             qps,
+		},
+		Slave: client2.Registry{
+			AccessKeyId:     accessKeyIdSlave,
+			AccessKeySecret: accessKeySecretSlave,
+			Endpoint:        endpointSlave,
+			InstanceId:      instanceIdSlave,
+			Network:         publicNetworkSlave,
+			Account:         accountSlave,
+			Password:        passwordSlave,
+			QPS:             qps,
+		},
+		OsFilterList:   osFilterList,
+		ArchFilterList: archFilterList,
+	}
+	names := make(map[string]bool)
+	for _, ns := range repoNamespaceNames {
+		syncerConfig.Namespaces = append(syncerConfig.Namespaces, client2.NamespaceRule{Name: ns, Dest: namespaceMapping[ns]})
+		names[ns] = true
+	}
+	for ns := range namespaceMapping {
+		if !names[ns] {
+			return nil, fmt.Errorf("invalid flags: namespaceMapping %s is not in repoNamespaceNames", ns)
+		}
+	}
+	if err := syncerConfig.ValidateFlags(); err != nil {
+		return nil, fmt.Errorf("invalid flags:\n%v", err)
+	}
+	return syncerConfig, nil
+}
+
+func Http(client *client2.Client, token, webhookToken string) {
+	// 通过http接口触发的同步排队依次执行
+	jobs := client2.NewJobManager(client, client2.DefaultJobQueueSize, client2.DefaultMaxJobs)
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	go jobs.Start(jobsCtx)
+
 	r := gin.Default()
 	r.Use(
 		func(c *gin.Context) {
@@ -85,13 +271,29 @@ func Http(client *client2.Client, token string) {
 		},
 		func(c *gin.Context) {
 			c.Set(KeyDep, client)
+			c.Set(KeyJobs, jobs)
 			c.Next()
 		},
 	)
 
 	group := r.Group("/api")
+	// 阿里云的webhook不能设置Authorization，也可以在url中通过 token 参数鉴权，没有配置 --webhookToken 时不开放
+	if webhookToken != "" {
+		group.POST("/webhook/acr", WebhookAuth(webhookToken), AcrWebhook)
+	} else {
+		log.Log().Msg("--webhookToken is not set, the acr webhook is disabled")
+	}
 	route := group.Use(Auth(token))
-	route.GET("/sync", Sync)
+	// 会修改从镜像仓库，只接受POST，避免链接预取和爬虫触发同步
+	route.POST("/sync", Sync)
+	route.GET("/jobs", Jobs)
+	route.GET("/jobs/:id", Job)
+	route.GET("/reports", Reports)
+	route.GET("/reports/:id", Report)
+	route.GET("/images", Images)
+	route.GET("/images/history", ImageHistory)
+	route.GET("/concurrency", GetConcurrency)
+	route.PUT("/concurrency", SetConcurrency)
 	server := &http.Server{Addr: ":8001", Handler: r}
 	go func() {
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -101,7 +303,7 @@ func Http(client *client2.Client, token string) {
 
 	log.Log().Msg("images-sync http is begining :)")
 
-	termination := make(chan os.Signal)
+	termination := make(chan os.Signal, 1)
 	signal.Notify(termination, syscall.SIGINT, syscall.SIGTERM)
 	<-termination
 
@@ -114,10 +316,195 @@ func Http(client *client2.Client, token string) {
 	log.Log().Msg("images-sync http shutting down :)")
 }
 
+// Sync 创建一个异步的同步任务，通过 /api/jobs/:id 查询结果
+// 可以通过 target=namespace[/repo[:tag]] 和 pair 参数只同步一个namespace、repo或者tag
 func Sync(c *gin.Context) {
+	if !c.MustGet(KeyDep).(*client2.Client).IsLeader() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"code": 503, "msg": client2.ErrNotLeader.Error()})
+		return
+	}
+
+	var target *client2.SyncTarget
+	if t := c.Query("target"); t != "" {
+		var err error
+		target, err = client2.ParseSyncTarget(c.Query("pair"), t)
+		if err == nil {
+			err = c.MustGet(KeyDep).(*client2.Client).CheckTarget(target)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": err.Error()})
+			return
+		}
+	}
+
+	jobs := c.MustGet(KeyJobs).(*client2.JobManager)
+	job, err := jobs.Submit(target)
+	if err != nil {
+		c.JSON(http.StatusTooManyRequests, gin.H{"code": 429, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"code": 202, "msg": "queued", "data": job})
+}
+
+// Jobs 所有的同步任务，最新的在前面
+func Jobs(c *gin.Context) {
+	jobs := c.MustGet(KeyJobs).(*client2.JobManager)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "success", "data": jobs.List()})
+}
+
+// Job 一个同步任务的状态、进度和每个镜像的同步结果
+func Job(c *gin.Context) {
+	jobs := c.MustGet(KeyJobs).(*client2.JobManager)
+	job, ok := jobs.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"code": 404, "msg": "job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "success", "data": job})
+}
+
+// Reports 所有同步报告的id，最新的在前面
+func Reports(c *gin.Context) {
+	client_ := c.MustGet(KeyDep).(*client2.Client)
+	ids, err := client_.Reports.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "success", "data": ids})
+}
+
+// Report 一轮同步的报告
+func Report(c *gin.Context) {
 	client_ := c.MustGet(KeyDep).(*client2.Client)
-	res := client_.Run()
-	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": res})
+	res, err := client_.Reports.Get(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			c.JSON(http.StatusNotFound, gin.H{"code": 404, "msg": "report not found"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "success", "data": res})
+}
+
+// Images 每个镜像的同步状态，prefix 参数按 registry/namespace/repo:tag 的前缀过滤
+func Images(c *gin.Context) {
+	client_ := c.MustGet(KeyDep).(*client2.Client)
+	if client_.State == nil {
+		c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "success", "data": []*state.ImageState{}})
+		return
+	}
+	states, err := client_.State.List(c.Query("prefix"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "success", "data": states})
+}
+
+// ImageHistory 一个镜像的同步历史，最新的在前面，destination 为 registry/namespace/repo:tag
+func ImageHistory(c *gin.Context) {
+	client_ := c.MustGet(KeyDep).(*client2.Client)
+	destination := c.Query("destination")
+	if destination == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "destination should not be empty"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": "invalid limit"})
+		return
+	}
+	if client_.State == nil {
+		c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "success", "data": []*state.ImageState{}})
+		return
+	}
+	history, err := client_.State.History(destination, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": 500, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "success", "data": history})
+}
+
+// GetConcurrency 当前的并发设置
+func GetConcurrency(c *gin.Context) {
+	client_ := c.MustGet(KeyDep).(*client2.Client)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "success", "data": client_.Concurrency()})
+}
+
+// SetConcurrency 修改并发设置，正在执行的同步不受影响，下一轮同步生效
+func SetConcurrency(c *gin.Context) {
+	client_ := c.MustGet(KeyDep).(*client2.Client)
+	concurrency := client_.Concurrency()
+	// 没有传的字段保持不变
+	if err := c.ShouldBindJSON(&concurrency); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": err.Error()})
+		return
+	}
+	if err := client_.SetConcurrency(concurrency); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": err.Error()})
+		return
+	}
+	log.Info().Msgf("concurrency changed to %+v, applies to the next round", concurrency)
+	c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "success", "data": concurrency})
+}
+
+// AcrWebhook 接收阿里云容器镜像服务的推送事件，立即同步推送的 repo:tag，url 中的 pair 参数指定主从
+func AcrWebhook(c *gin.Context) {
+	if !c.MustGet(KeyDep).(*client2.Client).IsLeader() {
+		// 返回错误让阿里云重试，重试时可能会路由到leader
+		c.JSON(http.StatusServiceUnavailable, gin.H{"code": 503, "msg": client2.ErrNotLeader.Error()})
+		return
+	}
+
+	data, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": err.Error()})
+		return
+	}
+	event, err := client2.ParseAcrPushEvent(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": 400, "msg": err.Error()})
+		return
+	}
+	target := event.Target(c.Query("pair"))
+	_client := c.MustGet(KeyDep).(*client2.Client)
+	err = _client.CheckTarget(target)
+	if err == nil {
+		err = _client.CheckTargetTag(target)
+	}
+	if err != nil {
+		// 没有配置同步的namespace或者tag被过滤规则排除，返回成功避免阿里云重复推送
+		c.JSON(http.StatusOK, gin.H{"code": 200, "msg": "ignored: " + err.Error()})
+		return
+	}
+
+	jobs := c.MustGet(KeyJobs).(*client2.JobManager)
+	job, err := jobs.Submit(target)
+	if err != nil {
+		c.JSON(http.StatusTooManyRequests, gin.H{"code": 429, "msg": err.Error()})
+		return
+	}
+	log.Info().Msgf("acr push event %s, sync job %s queued", target, job.ID)
+	c.JSON(http.StatusAccepted, gin.H{"code": 202, "msg": "queued", "data": job})
+}
+
+// WebhookAuth 支持 Authorization 和 url 中的 token 参数
+func WebhookAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		t := Token(c)
+		if t == "" {
+			t = c.Query("token")
+		}
+		if t != token {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "Unauthorized"})
+			return
+		}
+		c.Next()
+	}
 }
 
 func Auth(token string) gin.HandlerFunc {
@@ -140,9 +527,15 @@ func Token(c *gin.Context) string {
 }
 
 func init() {
+	RootCmd.PersistentFlags().StringVarP(&configPath, "config", "c", "", "同步规则配置文件(yaml/json)，指定后忽略命令行中的镜像仓库和namespace参数")
+
 	RootCmd.PersistentFlags().StringVar(&repoNamespaceName, "repoNamespaceName", "", "镜像仓库namespace, 默认主从是一样ns")
+	_ = RootCmd.PersistentFlags().MarkDeprecated("repoNamespaceName", "use --repoNamespaceNames or --config instead")
 
-	RootCmd.PersistentFlags().StringArrayVarP(&repoNamespaceNames, "repoNamespaceNames", "n", []string{"one", "two"}, "镜像仓库namespace, 默认主从是一样ns")
+	RootCmd.PersistentFlags().StringArrayVarP(&repoNamespaceNames, "repoNamespaceNames", "n", []string{"one", "two"}, "主镜像仓库namespace, 从镜像仓库默认使用同名ns，可以通过namespaceMapping映射")
+	RootCmd.PersistentFlags().StringSliceVar(&osFilterList, "os", nil, "只同步这些os的镜像，支持 os:osversion，比如 linux,windows:10.0.17763，manifest list 推送过滤后的列表，默认不过滤")
+	RootCmd.PersistentFlags().StringSliceVar(&archFilterList, "arch", nil, "只同步这些架构的镜像，支持 arch:variant，比如 amd64,arm64:v8，manifest list 推送过滤后的列表，默认不过滤")
+	RootCmd.PersistentFlags().StringToStringVarP(&namespaceMapping, "namespaceMapping", "m", map[string]string{}, "主从namespace映射，比如 dev-apps=prod-apps，repo名称改写请使用 --config")
 
 	RootCmd.PersistentFlags().StringVar(&accessKeyIdMaster, "accessKeyIdMaster", "", "主阿里云镜像仓库-key")
 	RootCmd.PersistentFlags().StringVar(&accessKeySecretMaster, "accessKeySecretMaster", "", "主阿里云镜像仓库-secret")
@@ -160,9 +553,30 @@ func init() {
 	RootCmd.PersistentFlags().StringVar(&passwordSlave, "passwordSlave", "", "从阿里云镜像仓库-密码")
 	RootCmd.PersistentFlags().StringVar(&instanceIdSlave, "instanceIdSlave", "", "从阿里云镜像仓库-实例id")
 
-	RootCmd.PersistentFlags().IntVarP(&procNum, "proc", "p", 5, "检查频率，默认10s检查一次是否有更新")
+	RootCmd.PersistentFlags().Float64Var(&qps, "qps", client2.DefaultQPS, "阿里云openapi每个实例每个接口的qps限制，免费版本为20")
+	RootCmd.PersistentFlags().IntVarP(&procNum, "proc", "p", 5, "生成和执行同步任务的协程数量")
 	RootCmd.PersistentFlags().IntVarP(&retries, "retries", "r", 2, "重试次数times to retry failed task")
+	RootCmd.PersistentFlags().IntVar(&registryConnections, "registryConnections", 0, "每个镜像仓库同时执行的同步任务数量，0为不限制")
+	RootCmd.PersistentFlags().IntVar(&taskTimeout, "taskTimeout", 0, "每个同步任务每次执行的超时时间(秒)，0为不限制")
+	RootCmd.PersistentFlags().IntVar(&blobWorkers, "blobWorkers", client2.DefaultConcurrency.BlobWorkers, "每个同步任务同时传输的blob数量")
+	RootCmd.PersistentFlags().IntVar(&taskBandwidth, "taskBandwidth", 0, "每个同步任务拉取blob的带宽限制(KB/s)，0为不限制")
 	RootCmd.PersistentFlags().StringVar(&logPath, "log", "", "日志log file path (default in os.Stderr)")
+	RootCmd.PersistentFlags().StringVar(&reportDir, "reportDir", "", "每一轮同步的json报告保存目录，为空时不保存")
+	RootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "只对比并检查需要传输的镜像和blob，不向从镜像仓库写入任何数据，也不保存报告和同步状态")
+	RootCmd.PersistentFlags().BoolVar(&verify, "verify", false, "同步后重新拉取从镜像仓库的manifest对比digest(manifest list逐个平台对比)，并HEAD检查所有blob，不一致时镜像同步失败")
+	RootCmd.PersistentFlags().BoolVar(&syncReferrers, "referrers", false, "同步镜像时一起同步cosign的 .sig/.att/.sbom 和OCI referrers(签名、SBOM、attestation)")
+	RootCmd.PersistentFlags().StringSliceVar(&referrerTypes, "referrerTypes", nil, "只同步这些artifactType的referrers，支持glob，比如 application/vnd.dev.cosign.*，默认全部")
+	RootCmd.PersistentFlags().StringSliceVar(&artifactTypes, "artifactTypes", nil, "只同步这些artifactType的非镜像制品(Helm chart、WASM等)，支持glob，比如 application/vnd.cncf.helm.*，默认全部")
+	RootCmd.PersistentFlags().StringSliceVar(&excludeArtifactTypes, "excludeArtifactTypes", nil, "不同步这些artifactType的非镜像制品，支持glob，优先于 --artifactTypes")
+	RootCmd.PersistentFlags().StringVar(&stateFile, "stateFile", "", "每个镜像同步状态的BoltDB文件，为空时不保存，同一个文件只能被一个进程使用")
+	RootCmd.PersistentFlags().IntVar(&stateHistory, "stateHistory", 100, "每个镜像在 --stateFile 中保留的同步历史数量，超过后删除最旧的历史，为0时不删除")
+
+	// 多副本部署时的选主
+	RootCmd.PersistentFlags().StringVar(&leaseBackend, "lease", "", "多副本选主方式 file/kubernetes，为空时不选主，单副本部署")
+	RootCmd.PersistentFlags().StringVar(&leaseFile, "leaseFile", "", "file选主的锁文件路径，多个副本需要使用同一个文件")
+	RootCmd.PersistentFlags().StringVar(&leaseName, "leaseName", "images-sync", "kubernetes选主的Lease名称")
+	RootCmd.PersistentFlags().StringVar(&leaseNamespace, "leaseNamespace", "", "kubernetes选主的Lease所在namespace，默认为pod所在namespace")
+	RootCmd.PersistentFlags().IntVar(&leaseDuration, "leaseDuration", 15, "leader超过这个时间(秒)没有续约，其它副本接管")
 
 	RootCmd.PersistentFlags().IntVarP(&polling, "polling", "o", 300, "轮询检查的时间间隔，默认300s执行一次")
 
@@ -174,6 +588,7 @@ func init() {
 
 	// http auth token
 	RootCmd.PersistentFlags().StringVar(&token, "token", "feiteng", "http接口鉴权token")
+	RootCmd.PersistentFlags().StringVar(&webhookToken, "webhookToken", "", "阿里云镜像推送webhook的鉴权token，需要和 --token 不同，为空时不开放webhook接口")
 }
 
 // Execute executes the RootCmd
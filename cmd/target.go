@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	client2 "aliyun-images-syncer/pkg/client"
+
+	"github.com/spf13/cobra"
+)
+
+var targetPair string
+
+// TargetCmd 只同步一个namespace、repo或者tag，同步结束后退出
+var TargetCmd = &cobra.Command{
+	Use:   "sync <namespace[/repo[:tag]]>",
+	Short: "Sync a single namespace, repo or tag immediately and exit",
+	Long: `Sync a single namespace, repo or tag immediately and exit.
+A namespace is diffed like a normal polling round, a repo or tag is synced directly without listing the registry.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		target, err := client2.ParseSyncTarget(targetPair, args[0])
+		if err != nil {
+			return err
+		}
+		_client, err := newClient()
+		if err != nil {
+			return err
+		}
+		defer _client.Close()
+		if err := _client.CheckTarget(target); err != nil {
+			return err
+		}
+
+		result := _client.RunTarget(target)
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return result.Err()
+	},
+}
+
+func init() {
+	TargetCmd.Flags().StringVar(&targetPair, "pair", "", "只同步指定的主从，默认同步所有包含这个namespace的主从")
+	RootCmd.AddCommand(TargetCmd)
+}
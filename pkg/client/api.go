@@ -7,6 +7,8 @@ import (
 	"sync"
 	"time"
 
+	"aliyun-images-syncer/pkg/tools"
+
 	cr20181201 "github.com/alibabacloud-go/cr-20181201/v2/client"
 	util "github.com/alibabacloud-go/tea-utils/v2/service"
 	"github.com/alibabacloud-go/tea/tea"
@@ -15,15 +17,19 @@ import (
 )
 
 type AlibabacloudApi struct {
-	Master             *Alibabacloud
-	Slave              *Alibabacloud
-	RepoNamespaceName  *string
-	RepoNamespaceNames []string
-	Logger             *logrus.Logger
-	PageSize           *int32
+	Master            *Alibabacloud
+	Slave             *Alibabacloud
+	RepoNamespaceName *string
+	// 同步规则
+	Config   *PairConfig
+	Logger   *logrus.Logger
+	PageSize *int32
+	// 所有api调用共用的令牌桶
+	Limiter *ApiLimiter
 }
 
 type Alibabacloud struct {
+	Name            string
 	Client          *cr20181201.Client
 	Account         *string
 	Password        *string
@@ -32,14 +38,17 @@ type Alibabacloud struct {
 	AccessKeySecret *string
 	Endpoint        *string
 	Network         *string
+	Insecure        bool
+	// openapi 每个接口的qps限制和令牌桶容量
+	QPS   float64
+	Burst int
 }
 
 type ApiClientEnum int
 
 const (
-	Master    ApiClientEnum = 1
-	Slave     ApiClientEnum = 2
-	FailFermi               = "fail-fermi"
+	Master ApiClientEnum = 1
+	Slave  ApiClientEnum = 2
 )
 
 type ApiError struct {
@@ -50,16 +59,16 @@ func (e *ApiError) Error() string {
 	return e.error
 }
 
-func NewAlibabacloudApi(clientMain *Alibabacloud, clientSlave *Alibabacloud, repoNamespaceName *string, repoNamespaceNames []string, logger *logrus.Logger) *AlibabacloudApi {
-	// 获取镜像上限，默认是30，超过30的时候镜像无法同步到
+func NewAlibabacloudApi(clientMain *Alibabacloud, clientSlave *Alibabacloud, config *PairConfig, limiter *ApiLimiter, logger *logrus.Logger) *AlibabacloudApi {
+	// 每页的数量，默认是30，超过的部分需要翻页获取
 	pageSize := int32(1000)
 	return &AlibabacloudApi{
-		Master:             clientMain,
-		Slave:              clientSlave,
-		RepoNamespaceName:  repoNamespaceName,
-		RepoNamespaceNames: repoNamespaceNames,
-		Logger:             logger,
-		PageSize:           &pageSize,
+		Master:   clientMain,
+		Slave:    clientSlave,
+		Config:   config,
+		Logger:   logger,
+		PageSize: &pageSize,
+		Limiter:  limiter,
 	}
 }
 
@@ -70,12 +79,34 @@ func (api *AlibabacloudApi) CurrentAlibabacloudApi(apiClientEnum ApiClientEnum)
 	return api.Slave
 }
 
-// ListRepository https://www.alibabacloud.com/help/zh/container-registry/latest/api-doc-cr-2018-12-01-api-doc-listrepository
-func (api *AlibabacloudApi) ListRepository(apiClientEnum ApiClientEnum, args ...[]*string) (*cr20181201.ListRepositoryResponse, error) {
+// namespace 返回当前同步的namespace，从镜像仓库使用映射后的namespace
+func (api *AlibabacloudApi) namespace(apiClientEnum ApiClientEnum) *string {
+	if apiClientEnum == Master {
+		return api.RepoNamespaceName
+	}
+	destNamespace := api.Config.DestNamespace(*api.RepoNamespaceName)
+	return &destNamespace
+}
+
+// ListRepository 翻页获取当前namespace下所有的镜像仓库，中途失败时返回已经拿到的数据和 PartialListError
+func (api *AlibabacloudApi) ListRepository(apiClientEnum ApiClientEnum) ([]*cr20181201.ListRepositoryResponseBodyRepositories, error) {
+	what := "repositories of " + *api.namespace(apiClientEnum)
+	return listAllPages(what, *api.PageSize, func(pageNo int32) ([]*cr20181201.ListRepositoryResponseBodyRepositories, string, error) {
+		res, err := api.ListRepositoryWithOptions(apiClientEnum, pageNo)
+		if err != nil {
+			return nil, "", err
+		}
+		return res.Body.Repositories, tea.StringValue(res.Body.TotalCount), nil
+	})
+}
+
+// ListRepositoryWithOptions https://www.alibabacloud.com/help/zh/container-registry/latest/api-doc-cr-2018-12-01-api-doc-listrepository
+func (api *AlibabacloudApi) ListRepositoryWithOptions(apiClientEnum ApiClientEnum, pageNo int32) (*cr20181201.ListRepositoryResponse, error) {
 	listRepositoryRequest := &cr20181201.ListRepositoryRequest{
 		InstanceId:        api.CurrentAlibabacloudApi(apiClientEnum).InstanceId,
 		RepoStatus:        tea.String("NORMAL"),
-		RepoNamespaceName: api.RepoNamespaceName,
+		RepoNamespaceName: api.namespace(apiClientEnum),
+		PageNo:            &pageNo,
 		PageSize:          api.PageSize,
 	}
 
@@ -87,7 +118,14 @@ func (api *AlibabacloudApi) ListRepository(apiClientEnum ApiClientEnum, args ...
 			}
 		}()
 		// 复制代码运行请自行打印 API 的返回值
-		resp, _err := api.CurrentAlibabacloudApi(apiClientEnum).Client.ListRepositoryWithOptions(listRepositoryRequest, runtime)
+		registry := api.CurrentAlibabacloudApi(apiClientEnum)
+		var resp *cr20181201.ListRepositoryResponse
+		_err := RetryTransient(DefaultRetryBackoff, func() error {
+			return api.Limiter.Do(context.Background(), registry, "ListRepository", func() (err error) {
+				resp, err = registry.Client.ListRepositoryWithOptions(listRepositoryRequest, runtime)
+				return err
+			})
+		})
 		if _err != nil {
 			return nil, _err
 		}
@@ -116,6 +154,22 @@ func (api *AlibabacloudApi) ListRepository(apiClientEnum ApiClientEnum, args ...
 	return res, nil
 }
 
+// ListRepoTags 翻页获取一个镜像仓库所有的tag，中途失败时返回已经拿到的数据和 PartialListError
+func (api *AlibabacloudApi) ListRepoTags(apiClientEnum ApiClientEnum, repo *RepoRequests) ([]*cr20181201.ListRepoTagResponseBodyImages, error) {
+	return listAllPages("tags of "+*repo.RepoName, *api.PageSize, func(pageNo int32) ([]*cr20181201.ListRepoTagResponseBodyImages, string, error) {
+		res, err := api.ListRepoTagWithOptions(apiClientEnum, &cr20181201.ListRepoTagRequest{
+			InstanceId: repo.InstanceId,
+			RepoId:     repo.RepoId,
+			PageNo:     &pageNo,
+			PageSize:   api.PageSize,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		return res.Body.Images, tea.StringValue(res.Body.TotalCount), nil
+	})
+}
+
 // ListRepoTagWithOptions https://www.alibabacloud.com/help/zh/container-registry/latest/api-doc-cr-2018-12-01-api-doc-listrepotag
 func (api *AlibabacloudApi) ListRepoTagWithOptions(apiClientEnum ApiClientEnum, listRepoTagRequest *cr20181201.ListRepoTagRequest) (*cr20181201.ListRepoTagResponse, error) {
 	// listRepoTagRequest := &cr20181201.ListRepoTagRequest{}
@@ -126,8 +180,15 @@ func (api *AlibabacloudApi) ListRepoTagWithOptions(apiClientEnum ApiClientEnum,
 				_e = r
 			}
 		}()
-		// 存在qps限制，免费版本 qps 20 ，每天50w次/接口
-		resp, err := api.CurrentAlibabacloudApi(apiClientEnum).Client.ListRepoTagWithOptions(listRepoTagRequest, runtime)
+		// 存在qps限制，免费版本 qps 20 ，每天50w次/接口，通过令牌桶控制
+		registry := api.CurrentAlibabacloudApi(apiClientEnum)
+		var resp *cr20181201.ListRepoTagResponse
+		err := RetryTransient(DefaultRetryBackoff, func() error {
+			return api.Limiter.Do(context.Background(), registry, "ListRepoTag", func() (err error) {
+				resp, err = registry.Client.ListRepoTagWithOptions(listRepoTagRequest, runtime)
+				return err
+			})
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -157,12 +218,14 @@ func (api *AlibabacloudApi) ListRepoTagWithOptions(apiClientEnum ApiClientEnum,
 }
 
 // ListRepoTagWithOptionsByRoutine 使用协程方式跑数据，避免批量执行的效率问题
-func (api *AlibabacloudApi) ListRepoTagWithOptionsByRoutine(apiClientEnum ApiClientEnum, listRepositoryResponseBodyRepositories []*cr20181201.ListRepositoryResponseBodyRepositories) (map[string]string, error) {
+// 返回每个repo的拉取结果，拉取失败的repo Err 不为空，没有tag的repo Tags 为空
+// selector 不为空时只返回满足tag过滤规则的tag
+func (api *AlibabacloudApi) ListRepoTagWithOptionsByRoutine(apiClientEnum ApiClientEnum, listRepositoryResponseBodyRepositories []*cr20181201.ListRepositoryResponseBodyRepositories, selector *tools.RepoTagSelector) (tools.RepoTagsMap, error) {
 	// 准备数据
 	repoRequestMaps := api.repoRequestMap(apiClientEnum, listRepositoryResponseBodyRepositories)
 
 	requestsSlice := repoRequestMaps[api.CurrentAlibabacloudApi(apiClientEnum).InstanceId]
-	// 阿里云镜像api免费版本有qps限制，需要保证少于 20/s ,假设我们的镜像仓库需要有200镜像，一组差不多5，协程20个，然后sleep再下一个
+	// 阿里云镜像api免费版本有qps限制，每个协程处理一组repo，qps 由令牌桶统一控制，不再需要sleep
 	repoRequestsSlice := RepoRequestsSlice(requestsSlice).RepoRequestsSliceSplit(3)
 
 	// 保重10分钟中内要完成，避免协程泄漏
@@ -170,50 +233,69 @@ func (api *AlibabacloudApi) ListRepoTagWithOptionsByRoutine(apiClientEnum ApiCli
 	defer cancel()
 	wg, _ := errgroup.WithContext(ctx)
 
-	// 线程安全 map
-	m := sync.Map{}
-	lastRepoTagMap := make(map[string]string)
+	var mu sync.Mutex
+	repoTagsMap := make(tools.RepoTagsMap)
 
 	// 按length 开启多个goroutine
 	for _, row := range repoRequestsSlice {
 		repos := row
 		wg.Go(func() error {
 			for _, repo := range repos {
-				// 单次错误，不做记录，避免某一次访问的失败导致所以失败，因为要不断的循环事务，下次同步可能就自动修复更新了
-				pageSize := int32(1000) // 为了避免服务挂掉重启的时候，漏掉一些tag，因为一些老tag可能会用到，这里检查每个镜像仓库所有的tag
-				res, err := api.ListRepoTagWithOptions(apiClientEnum, &cr20181201.ListRepoTagRequest{InstanceId: repo.InstanceId, RepoId: repo.RepoId, PageSize: &pageSize})
-				if err != nil || len(res.Body.Images) <= 0 {
-					api.Logger.Errorf("ListRepoTagWithOptions %s && %s get error: %v", *repo.InstanceId, *repo.RepoId, err)
-					// 失败的请求先扔到map里，因为可能会遇到qps限制和阿里抽风的情况，存入约定好的标识，遇到直接跳过检查！
-					m.Store(*repo.RepoName, FailFermi)
-					continue
-				}
-				sortRes := ListRepoTagResponseBodyImagesSlice(res.Body.Images).SortTags()
-				// 存储hash : "clickhouse-cluster" => "v0.0.1"  tag为最新push的images
-				// 11-17 更新为检查最新的30个tag，因为存在断点重传的情况，而且这个服务5分钟执行一次，可能存在5分钟中同一个镜像增加多次更新
-				for _, sortTag := range sortRes {
-					m.Store(*repo.RepoName+":"+*sortTag.Tag, *sortTag.Tag)
-				}
+				repoTags := api.listRepoTags(apiClientEnum, repo, selector)
+				mu.Lock()
+				repoTagsMap[repoTags.Repo] = repoTags
+				mu.Unlock()
 			}
 			return nil
 		})
-		time.Sleep(500 * time.Millisecond)
 	}
 
 	if err := wg.Wait(); err != nil {
 		return nil, err
 	}
+	return repoTagsMap, nil
+}
 
-	m.Range(func(key, value interface{}) bool {
-		k, ok1 := key.(string)
-		v, ok2 := value.(string)
-		if ok1 && ok2 {
-			lastRepoTagMap[k] = v
-		}
-		return true
-	})
+// listRepoTags 拉取一个repo的所有tag
+func (api *AlibabacloudApi) listRepoTags(apiClientEnum ApiClientEnum, repo *RepoRequests, selector *tools.RepoTagSelector) *tools.RepoTags {
+	repoTags := &tools.RepoTags{Repo: *repo.RepoName}
+	// 超时、5xx 这类临时错误已经在 api 调用处退避重试过了，这里拿到的是重试后仍然失败的错误
+	// 不影响其它repo，下次同步可能就自动修复更新了
+	// 为了避免服务挂掉重启的时候，漏掉一些tag，因为一些老tag可能会用到，这里翻页检查每个镜像仓库所有的tag
+	// 只拿到部分tag的repo也当做失败处理，避免把不完整的列表当成完整的做对比
+	images, err := api.ListRepoTags(apiClientEnum, repo)
+	if err != nil {
+		api.Logger.Errorf("ListRepoTagWithOptions %s && %s get error: %v", *repo.InstanceId, *repo.RepoId, err)
+		repoTags.Err = err
+		return repoTags
+	}
+	// 使用digest而不是tag做对比，latest这类会被重复push的tag内容变化后也能重新同步
+	tagInfos := make([]tools.TagInfo, 0, len(images))
+	for _, image := range ListRepoTagResponseBodyImagesSlice(images).SortTags() {
+		tagInfos = append(tagInfos, tools.TagInfo{Tag: *image.Tag, Digest: tagDigest(image), Update: tagUpdateTime(image)})
+	}
+	repoTags.Tags = selector.Select(repoTags.Repo, tagInfos, time.Now())
+	return repoTags
+}
 
-	return lastRepoTagMap, nil
+// tagDigest 获取tag对应的digest，接口没有返回digest时退化成使用tag对比
+func tagDigest(image *cr20181201.ListRepoTagResponseBodyImages) string {
+	if image.Digest != nil && *image.Digest != "" {
+		return *image.Digest
+	}
+	return *image.Tag
+}
+
+// tagUpdateTime 解析tag的更新时间，ImageUpdate 为毫秒时间戳
+func tagUpdateTime(image *cr20181201.ListRepoTagResponseBodyImages) time.Time {
+	if image.ImageUpdate == nil {
+		return time.Time{}
+	}
+	millis, err := strconv.ParseInt(*image.ImageUpdate, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(millis)
 }
 
 type ListRepoTagResponseBodyImagesSlice []*cr20181201.ListRepoTagResponseBodyImages
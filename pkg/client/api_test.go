@@ -32,3 +32,13 @@ func TestSortTags(t *testing.T) {
 	}
 
 }
+
+func TestTagDigest(t *testing.T) {
+	tag, digest, empty := "latest", "sha256:a1", ""
+	if res := tagDigest(&client.ListRepoTagResponseBodyImages{Tag: &tag, Digest: &digest}); res != digest {
+		t.Errorf("tagDigest should use digest, now is -> %s", res)
+	}
+	if res := tagDigest(&client.ListRepoTagResponseBodyImages{Tag: &tag, Digest: &empty}); res != tag {
+		t.Errorf("tagDigest should fall back to tag, now is -> %s", res)
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"container/list"
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 	sync2 "sync"
 	"time"
 
+	"aliyun-images-syncer/pkg/lease"
 	"aliyun-images-syncer/pkg/middleware"
+	"aliyun-images-syncer/pkg/state"
 	"aliyun-images-syncer/pkg/sync"
 	"aliyun-images-syncer/pkg/tools"
 
@@ -22,14 +25,10 @@ import (
 )
 
 type Client struct {
-	// 主(dev)镜像仓库，用来拉镜像列表
-	clientMaster *cr20181201.Client
-	// 从(prod)镜像仓库，用来同步镜像
-	clientSlave *cr20181201.Client
 	// 日志对象
 	Logger *logrus.Logger
-	// 阿里镜像仓库api
-	alibabacloudApi *AlibabacloudApi
+	// 所有的主从，每组主从一个主(dev)镜像仓库用来拉镜像列表，多个从(prod)镜像仓库用来同步镜像
+	pairs []*SyncPair
 	// 邮件服务
 	MailClient *middleware.MailClient
 
@@ -47,6 +46,49 @@ type Client struct {
 
 	routineNum int
 	retries    int
+	// 每个同步任务的超时时间和每个镜像仓库的并发限制
+	taskTimeout     time.Duration
+	registryLimiter *registryLimiter
+	// 每个同步任务的带宽限制，bytes/s
+	taskBandwidth int
+	// 每个同步任务同时传输的blob数量
+	blobWorkers int
+	// 同一轮同步中多个镜像共用的blob只传输一次
+	blobGroup *sync.BlobGroup
+
+	// 并发设置，每一轮同步开始时读取，roundConcurrency 为当前这一轮使用的设置
+	concurrency      Concurrency
+	concurrencyMu    sync2.Mutex
+	roundConcurrency Concurrency
+
+	// 每一轮同步的报告
+	Reports *ReportStore
+	// 保证同一时间只有一轮同步
+	coordinator *RunCoordinator
+	// 多副本部署时的选主，为空时总是执行同步
+	Leader *lease.Elector
+	// 当前这一轮同步的ctx，失去leader时取消
+	roundCtx context.Context
+	// 每个镜像的同步状态，为空时不保存
+	State state.Store
+	// 当前这一轮同步报告的id
+	runID string
+	// 是否已经恢复过上次中断的同步
+	resumed bool
+	// 只对比和检查需要传输的blob，不向从镜像仓库写入任何数据，也不保存报告和同步状态
+	DryRun bool
+	// 同步镜像时一起同步的签名、SBOM和attestation，nil 为不同步
+	Referrers *sync.ReferrersOptions
+	// 同步的Helm chart等非镜像制品，nil 为全部同步
+	Artifacts *sync.ArtifactOptions
+	// 同步后重新拉取从镜像仓库的manifest并检查所有blob，不一致时镜像同步失败
+	Verify bool
+	// 当前从镜像仓库每个镜像的同步结果，source url => report
+	imageReports map[string]*ImageReport
+	// 当前这一轮同步的进度，已经结束的destination的镜像计数
+	progress RunProgress
+	// imageReports 和 progress 的锁
+	imageReportsMu sync2.Mutex
 
 	// mutex
 	taskListChan               chan int
@@ -64,78 +106,70 @@ type URLPair struct {
 	destination string
 }
 
-// CreateClient 使用AK&SK初始化账号Client
-func CreateClient(accessKeyIdMaster, accessKeySecretMaster, endpointMaster, accountMaster, passwordMaster *string,
-	accessKeyIdSlave, accessKeySecretSlave, endpointSlave, accountSlave, passwordSlave *string,
-	repoNamespaceName, instanceIdMaster, instanceIdSlave *string,
-	publicNetworkMaster, publicNetworkSlave *string,
+// CreateClient 使用AK&SK初始化账号Client，syncerConfig 需要事先通过 Validate 校验
+// polling 为没有单独配置轮询间隔的主从使用的默认值
+func CreateClient(syncerConfig *SyncerConfig, polling time.Duration,
 	mailHost, mailUserName, mailAuthCode, mailTo string,
-	logFile string, repoNamespaceNames []string, dep *Dependency) (client *Client, err error) {
+	logFile, reportDir string, dep *Dependency) (client *Client, err error) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	var (
-		clientMaster *cr20181201.Client
-		clientSlave  *cr20181201.Client
-	)
 
+	logger := NewFileLogger(logFile)
 	wg, gCtx := errgroup.WithContext(ctx)
-	// 初始化主镜像仓库的client
-	wg.Go(func() error {
-		clientMaster, err = CreateAliOpenapiClient(gCtx, accessKeyIdMaster, accessKeySecretMaster, endpointMaster)
-		if err != nil {
-			return err
+	// 初始化镜像仓库的client
+	newOpenapiClient := func(registry *Alibabacloud) {
+		wg.Go(func() error {
+			openapiClient, err := CreateAliOpenapiClient(gCtx, registry.AccessKeyId, registry.AccessKeySecret, registry.Endpoint)
+			if err != nil {
+				return err
+			}
+			registry.Client = openapiClient
+			return nil
+		})
+	}
+
+	// 所有主从共用一个令牌桶，同一个实例被多组主从使用时也不会超过qps限制
+	limiter := NewApiLimiter()
+	var pairs []*SyncPair
+	for _, pairConfig := range syncerConfig.SyncPairs() {
+		pair := &SyncPair{
+			Name:    pairConfig.Name,
+			Config:  pairConfig,
+			Polling: polling,
+		}
+		if pairConfig.Polling > 0 {
+			pair.Polling = time.Duration(pairConfig.Polling) * time.Second
 		}
-		return nil
-	})
 
-	// 初始化副镜像仓库的client
-	wg.Go(func() error {
-		clientSlave, err = CreateAliOpenapiClient(gCtx, accessKeyIdSlave, accessKeySecretSlave, endpointSlave)
-		if err != nil {
-			return err
+		master := pairConfig.Master.alibabacloud()
+		newOpenapiClient(master)
+		for i := range pairConfig.Slaves {
+			slave := pairConfig.Slaves[i].alibabacloud()
+			newOpenapiClient(slave)
+			// 封装一个api的包
+			pair.Apis = append(pair.Apis, NewAlibabacloudApi(master, slave, pairConfig, limiter, logger))
 		}
-		return nil
-	})
+		pairs = append(pairs, pair)
+	}
 
 	if err := wg.Wait(); err != nil {
 		return nil, err
 	}
 
-	logger := NewFileLogger(logFile)
+	reports, err := NewReportStore(reportDir)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Client{
-		clientMaster: clientMaster,
-		clientSlave:  clientSlave,
-		Logger:       logger,
-		MailClient:   middleware.NewMailClient(mailHost, mailUserName, mailAuthCode, mailTo),
-		// 封装一个api的包
-		alibabacloudApi: NewAlibabacloudApi(
-			&Alibabacloud{
-				Client:          clientMaster,
-				Account:         accountMaster,
-				Password:        passwordMaster,
-				InstanceId:      instanceIdMaster,
-				AccessKeyId:     accessKeyIdMaster,
-				AccessKeySecret: accessKeySecretMaster,
-				Endpoint:        endpointMaster,
-				Network:         publicNetworkMaster,
-			},
-			&Alibabacloud{
-				Client:          clientSlave,
-				Account:         accountSlave,
-				Password:        passwordSlave,
-				InstanceId:      instanceIdSlave,
-				AccessKeyId:     accessKeyIdSlave,
-				AccessKeySecret: accessKeySecretSlave,
-				Endpoint:        endpointSlave,
-				Network:         publicNetworkSlave,
-			},
-			repoNamespaceName,
-			repoNamespaceNames,
-			logger,
-		),
-		Dep: dep,
-	}, err
+		Logger:      logger,
+		pairs:       pairs,
+		MailClient:  middleware.NewMailClient(mailHost, mailUserName, mailAuthCode, mailTo),
+		Reports:     reports,
+		coordinator: NewRunCoordinator(logger),
+		Dep:         dep,
+	}, nil
 }
 
 func CreateAliOpenapiClient(ctx context.Context, accessKeyId, accessKeySecret, endpoint *string) (*cr20181201.Client, error) {
@@ -155,101 +189,281 @@ func CreateAliOpenapiClient(ctx context.Context, accessKeyId, accessKeySecret, e
 	return client, nil
 }
 
-func (c *Client) Run() string {
-	log.Log().Msg("Start scanning ...")
+// Close 释放client持有的资源
+func (c *Client) Close() error {
+	if c.State != nil {
+		return c.State.Close()
+	}
+	return nil
+}
+
+// IsLeader 当前副本是否可以执行同步
+func (c *Client) IsLeader() bool {
+	return c.Leader.IsLeader()
+}
+
+// leading 当前这一轮同步是否还是leader，失去leader后不再同步剩下的主从和镜像，避免两个副本同时推送同一个tag
+func (c *Client) leading() bool {
+	return c.roundCtx == nil || c.roundCtx.Err() == nil
+}
 
-	if _, ok := c.Dep.Lru.Get("syncing"); ok {
-		log.Log().Msg("Syncing, please wait ...")
-		return "Some images is syncing, please wait ..."
+// PollingInterval 所有主从中最短的轮询间隔，用来驱动轮询的ticker
+func (c *Client) PollingInterval() time.Duration {
+	var interval time.Duration
+	for _, pair := range c.pairs {
+		if interval == 0 || pair.Polling < interval {
+			interval = pair.Polling
+		}
 	}
-	c.Dep.Lru.Add("syncing", 1)
+	return interval
+}
+
+// Run 同步所有的主从，正在同步时等待，同步期间多次触发只会再同步一轮
+func (c *Client) Run() *RunResult {
+	return c.RunJob(nil, nil)
+}
 
-	// time.Sleep(5 * time.Second)
-	for _, ns := range c.alibabacloudApi.RepoNamespaceNames {
-		c.Sync(ns)
+// RunJob 执行一次http接口触发的同步，target 为空时同步所有的主从，否则定向同步，
+// started 在这一轮同步开始执行时调用，通过同步的id区分进度是不是这一轮的
+func (c *Client) RunJob(target *SyncTarget, started func(runID string)) *RunResult {
+	if target != nil {
+		return c.runTarget(target, started)
 	}
+	return c.coordinator.Do("all", started, func(id string) *RunResult {
+		return c.run(id, time.Now(), func(pair *SyncPair) bool { return true }, nil)
+	})
+}
 
-	c.Dep.Lru.Remove("syncing")
-	log.Log().Msg("End scanning ...")
-	return "success"
+// RunDue 只同步已经到了轮询间隔的主从
+func (c *Client) RunDue() *RunResult {
+	return c.coordinator.Do("due", nil, func(id string) *RunResult {
+		now := time.Now()
+		return c.run(id, now, func(pair *SyncPair) bool { return pair.due(now) }, nil)
+	})
 }
-func (c *Client) Sync(ns string) {
-	fmt.Printf("Start scanning the difference between master and slave images ...,namespance is %s\n", ns)
 
-	// prepare
-	c.Prepare()
-	c.alibabacloudApi.RepoNamespaceName = &ns
+// run 同步满足 filter 的主从，target 不为空时只同步指定的namespace、repo或者tag，
+// id 为这一轮同步的id，now 为触发同步的时间，作为选中的主从的上一次同步时间
+func (c *Client) run(id string, now time.Time, filter func(pair *SyncPair) bool, target *SyncTarget) *RunResult {
+	if !c.IsLeader() {
+		log.Debug().Msg("Not the leader, skip syncing ...")
+		return &RunResult{Msg: ErrNotLeader.Error()}
+	}
+	log.Log().Msg("Start scanning ...")
+
+	// 服务重启后第一轮同步先恢复上次中断的镜像
+	var resumeTargets []*SyncTarget
+	if target == nil && !c.resumed && !c.DryRun {
+		c.resumed = true
+		resumeTargets = c.resumeTargets()
+	}
 
-	// 1. get mster and slave tags
 	var (
-		respListMaster *cr20181201.ListRepositoryResponse
-		respListSlave  *cr20181201.ListRepositoryResponse
-		err            error
-		tagMapsMaster  map[string]string
-		tagMapsSlave   map[string]string
+		pairs        []*SyncPair
+		destinations int
 	)
-
-	wg, _ := errgroup.WithContext(context.Background())
-	wg.Go(func() error {
-		// 获取镜像仓库列表
-		respListMaster, err = c.alibabacloudApi.ListRepository(Master)
-		if err != nil {
-			c.Logger.Error("Master ListRepository err", err)
-			return err
+	for _, t := range resumeTargets {
+		destinations += len(c.pair(t.Pair).Apis)
+	}
+	for _, pair := range c.pairs {
+		if filter(pair) {
+			pairs = append(pairs, pair)
+			destinations += len(pair.Apis) * len(target.namespaces(pair))
+			if target == nil {
+				// 按触发的时间记录，不受前面的主从同步耗时影响，定向同步不影响轮询间隔
+				pair.lastRun = now
+			}
 		}
-		// 获取每一个镜像最新tag
-		tagMapsMaster, err = c.alibabacloudApi.ListRepoTagWithOptionsByRoutine(Master, respListMaster.Body.Repositories)
-		if err != nil {
-			c.Logger.Error("Master ListRepoTagWithOptionsByRoutine err", err)
-			return err
+	}
+	c.resetProgress(id, destinations)
+
+	startedAt := time.Now().UTC()
+	result := &RunResult{ID: id, StartedAt: startedAt, Msg: "success", DryRun: c.DryRun}
+	c.runID = result.ID
+	c.roundConcurrency = c.Concurrency()
+	c.blobGroup = sync.NewBlobGroup()
+	var cancel context.CancelFunc
+	c.roundCtx, cancel = c.Leader.LeaderContext(context.Background())
+	defer cancel()
+	for _, t := range resumeTargets {
+		if !c.leading() {
+			break
 		}
-		return nil
-	})
+		result.Pairs = append(result.Pairs, c.SyncTarget(c.pair(t.Pair), t.Namespace, t)...)
+	}
+	for _, pair := range pairs {
+		if !c.leading() {
+			break
+		}
+		for _, ns := range target.namespaces(pair) {
+			if !c.leading() {
+				break
+			}
+			if target != nil && target.Repo != "" {
+				result.Pairs = append(result.Pairs, c.SyncTarget(pair, ns, target)...)
+			} else {
+				result.Pairs = append(result.Pairs, c.Sync(pair, ns)...)
+			}
+		}
+	}
 
-	time.Sleep(300 * time.Millisecond)
+	if !c.leading() {
+		c.Logger.Errorf("Round %s is stopped: %v", result.ID, ErrLostLeader)
+		result.Msg = ErrLostLeader.Error()
+	}
 
-	wg.Go(func() error {
-		// 获取镜像仓库列表
-		respListSlave, err = c.alibabacloudApi.ListRepository(Slave)
-		if err != nil {
-			c.Logger.Error("Slave err", err)
-			return err
-		}
-		// 获取每一个镜像最新tag
-		tagMapsSlave, err = c.alibabacloudApi.ListRepoTagWithOptionsByRoutine(Slave, respListSlave.Body.Repositories)
-		if err != nil {
-			c.Logger.Error("Slave ListRepoTagWithOptionsByRoutine err", err)
-			return err
+	result.FinishedAt = time.Now().UTC()
+	progress := c.Progress()
+	result.Progress = &progress
+	if c.DryRun {
+		log.Log().Msg("Dry run, the report is not saved ...")
+	} else if err := c.Reports.Save(result); err != nil {
+		c.Logger.Errorf("Save report %s error: %v", result.ID, err)
+	}
+
+	log.Log().Msg("End scanning ...")
+	return result
+}
+
+// Sync 同步一组主从的一个namespace，主镜像仓库只拉取一次镜像列表，然后依次同步到每个从镜像仓库
+func (c *Client) Sync(pair *SyncPair, ns string) []*PairSummary {
+	fmt.Printf("Start scanning the difference between master and slave images ...,pair is %s, namespance is %s\n", pair.Name, ns)
+
+	for _, api := range pair.Apis {
+		api.RepoNamespaceName = &ns
+	}
+
+	// 1. get mster tags
+	var (
+		summaries     []*PairSummary
+		tagMapsMaster tools.RepoTagsMap
+	)
+	masterApi := pair.Apis[0]
+	selector, err := pair.Config.TagSelector(ns)
+	if err == nil {
+		tagMapsMaster, err = c.listRepoTags(masterApi, Master, selector)
+	}
+	if err != nil {
+		fmt.Println("get master images list fail，Wait for the next inspection...", err)
+		for _, api := range pair.Apis {
+			summary := &PairSummary{
+				Pair:        pair.Name,
+				Destination: api.Slave.Name,
+				Namespace:   ns,
+				Error:       err.Error(),
+			}
+			c.finishProgress(summary)
+			summaries = append(summaries, summary)
 		}
-		return nil
-	})
+		return summaries
+	}
 
-	if err := wg.Wait(); err != nil {
-		fmt.Println("get images list fail，Wait for the next inspection...", err)
-		return
+	for _, api := range pair.Apis {
+		c.startProgress(pair.Name + "/" + api.Slave.Name + "/" + ns)
+		summary := c.syncDestination(api, tagMapsMaster)
+		summary.addListFailures(api.Master.Name, tagMapsMaster)
+		c.finishProgress(summary)
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// listRepoTags 获取镜像仓库列表以及每一个镜像的tag，selector 不为空时只返回满足tag过滤规则的tag
+func (c *Client) listRepoTags(api *AlibabacloudApi, apiClientEnum ApiClientEnum, selector *tools.RepoTagSelector) (tools.RepoTagsMap, error) {
+	// 获取镜像仓库列表
+	// 只拿到部分镜像仓库时不做对比，否则从镜像仓库缺失的repo会被当成需要同步
+	repositories, err := api.ListRepository(apiClientEnum)
+	if err != nil {
+		c.Logger.Error("ListRepository err", err)
+		return nil, err
+	}
+	// 获取每一个镜像最新tag
+	tagMaps, err := api.ListRepoTagWithOptionsByRoutine(apiClientEnum, repositories, selector)
+	if err != nil {
+		c.Logger.Error("ListRepoTagWithOptionsByRoutine err", err)
+		return nil, err
 	}
+	return tagMaps, nil
+}
 
-	// tagMapsMaster = map[string]string{"dictionary:v1.3.2": "v1.3.2", "ds3_serving:v1.3.6": "v1.3.6", "hodorcms:v0.0.2-beta.5": "v0.0.2-beta.5", "images-sync:v0.0.5": "v0.0.5", "images-sync:v0.0.7": "v0.0.7", "images-sync:v0.0.8": "v0.0.8", "images-sync:v0.1.0": "v0.1.0", "images-sync:v0.1.1": "v0.1.1"}
-	// tagMapsSlave = map[string]string{"dictionary:v1.3.2": "v1.3.2"}
+// syncDestination 对比主镜像仓库和一个从镜像仓库，同步有差异的镜像
+func (c *Client) syncDestination(api *AlibabacloudApi, tagMapsMaster tools.RepoTagsMap) *PairSummary {
+	summary := &PairSummary{
+		Pair:        api.Config.Name,
+		Destination: api.Slave.Name,
+		Namespace:   *api.RepoNamespaceName,
+	}
+	fmt.Printf("Start syncing to %s ...\n", summary.Destination)
+
+	// prepare
+	c.Prepare()
+
+	// 1. get slave tags
+	tagMapsSlave, err := c.listRepoTags(api, Slave, nil)
+	if err != nil {
+		fmt.Println("get slave images list fail，Wait for the next inspection...", err)
+		summary.Error = err.Error()
+		return summary
+	}
+	summary.addListFailures(api.Slave.Name, tagMapsSlave)
 
 	// 2. filter need sync data
+	// 主镜像仓库的repo名称先按规则改写成从镜像仓库的名称，再做对比
+	rewriter, err := api.Config.RepoRewriter(summary.Namespace)
+	if err != nil {
+		summary.Error = err.Error()
+		return summary
+	}
+	tagMapsMaster, sources, err := tools.RewriteRepoTagsMap(tagMapsMaster, rewriter)
+	if err != nil {
+		// 冲突的repo不做同步，其它repo正常同步
+		c.Logger.Errorf("Rewrite repo name for %s error: %v", summary.Destination, err)
+		summary.Error = err.Error()
+	}
+
+	// syncMap 为 repo:tag => missing/repo missing/digest drift
 	syncMap := tools.RepoTagsMapDiff(tagMapsMaster, tagMapsSlave)
-	fmt.Println("Get the data that needs to be synchronized ...")
+	// 上次同步后主从digest都没有变化的镜像不再同步，比如按os/arch过滤过的manifest list
+	summary.Verified = c.skipVerified(api, syncMap, tagMapsMaster, tagMapsSlave)
+	missingRepos := make(map[string]struct{})
+	for image, reason := range syncMap {
+		switch reason {
+		case tools.DiffDigestDrift:
+			summary.DigestDrift++
+		case tools.DiffRepoMissing:
+			summary.Missing++
+			missingRepos[strings.SplitN(image, ":", 2)[0]] = struct{}{}
+		default:
+			summary.Missing++
+		}
+	}
+	summary.MissingRepos = len(missingRepos)
+	fmt.Printf("Get the data that needs to be synchronized ..., %d missing (%d repos missing), %d digest drift\n", summary.Missing, summary.MissingRepos, summary.DigestDrift)
 	console.Log(util.ToJSONString(syncMap))
 	if len(syncMap) <= 0 {
 		fmt.Println("No image update，Wait for the next inspection...")
-		return
+		return summary
 	}
 
 	// 3. syncing
+	c.syncImages(api, summary, syncMap, sources)
+	return summary
+}
+
+// syncImages 把 syncMap 中的镜像从主镜像仓库同步到从镜像仓库，sources 为从镜像仓库的 repo => 主镜像仓库的 repo
+func (c *Client) syncImages(api *AlibabacloudApi, summary *PairSummary, syncMap map[string]string, sources map[string]string) {
+	c.addProgressImages(len(syncMap))
 	fmt.Println("Start to generate sync tasks, please wait ...")
 
-	configs, err := c.alibabacloudApi.NewSyncConfig(syncMap, []string{}, []string{})
+	osFilterList, archFilterList := api.Config.PlatformFilter(summary.Namespace)
+	configs, err := api.NewSyncConfig(syncMap, sources, osFilterList, archFilterList)
 	if err != nil {
 		c.Logger.Error("NewSyncConfig err", err)
+		summary.Error = err.Error()
 		return
 	}
 	c.config = configs
+	c.markPending(summary)
 
 	// 下面是基于：github.com/AliyunContainerService/image-syncer manifest构建images，修改了config的配置，只使用内存不占用磁盘，经过测试这种方式最稳定！
 	// open num of goroutines and wait c for close
@@ -268,6 +482,7 @@ func (c *Client) Sync(ns string) {
 					moreURLPairs, err := c.GenerateSyncTask(urlPair.source, urlPair.destination)
 					if err != nil {
 						c.Logger.Errorf("Generate sync task %s to %s error: %v", urlPair.source, urlPair.destination, err)
+						c.putImageReport(&ImageReport{Source: urlPair.source, Destination: urlPair.destination, Error: err.Error()})
 						// put to failedTaskGenerateList
 						c.PutAFailedURLPair(urlPair)
 					}
@@ -292,7 +507,22 @@ func (c *Client) Sync(ns string) {
 					if empty {
 						break
 					}
-					if err := task.Run(); err != nil {
+					if !c.leading() {
+						// 失去leader后剩下的任务不再执行
+						c.putImageReport(newTaskReport(task, ErrLostLeader))
+						c.PutAFailedTask(task)
+						continue
+					}
+					release := c.registryLimiter.acquire(task.GetSource().GetRegistry(), task.GetDestination().GetRegistry())
+					var err error
+					if c.DryRun {
+						err = task.Plan()
+					} else {
+						err = task.Run()
+					}
+					release()
+					c.putImageReport(newTaskReport(task, err))
+					if err != nil {
 						// put to failedTaskList
 						c.PutAFailedTask(task)
 					}
@@ -318,7 +548,7 @@ func (c *Client) Sync(ns string) {
 	// generate goroutines to handle sync tasks
 	openRoutinesHandleTaskAndWaitForFinish()
 
-	for times := 0; times < c.retries; times++ {
+	for times := 0; times < c.retries && c.leading(); times++ {
 		if c.failedTaskGenerateList.Len() != 0 {
 			c.urlPairList.PushBackList(c.failedTaskGenerateList)
 			c.failedTaskGenerateList.Init()
@@ -339,9 +569,21 @@ func (c *Client) Sync(ns string) {
 		}
 	}
 
-	fmt.Printf("Finished, %v sync tasks failed, %v tasks generate failed\n", c.failedTaskList.Len(), c.failedTaskGenerateList.Len())
-	c.Logger.Infof("Finished, %v sync tasks failed, %v tasks generate failed", c.failedTaskList.Len(), c.failedTaskGenerateList.Len())
-
+	summary.FailedTasks = c.failedTaskList.Len()
+	summary.FailedGenerate = c.failedTaskGenerateList.Len()
+	for _, report := range c.imageReports {
+		report.complete(summary.Namespace, syncMap)
+		summary.Images = append(summary.Images, report)
+		if len(report.FilteredPlatforms) != 0 {
+			summary.Filtered++
+		}
+	}
+	sort.Slice(summary.Images, func(i, j int) bool {
+		return summary.Images[i].Source < summary.Images[j].Source
+	})
+	c.saveStates(summary)
+	fmt.Printf("Finished %s, %v sync tasks failed, %v tasks generate failed\n", summary.Destination, summary.FailedTasks, summary.FailedGenerate)
+	c.Logger.Infof("Finished %s, %v sync tasks failed, %v tasks generate failed", summary.Destination, summary.FailedTasks, summary.FailedGenerate)
 }
 
 // Prepare 每轮sync 初始化一些configs
@@ -354,8 +596,88 @@ func (c *Client) Prepare() {
 	c.urlPairListChan = make(chan int, 1)
 	c.failedTaskListChan = make(chan int, 1)
 	c.failedTaskGenerateListChan = make(chan int, 1)
-	c.routineNum = 5
-	c.retries = 2
+	c.routineNum = c.roundConcurrency.Workers
+	c.retries = c.roundConcurrency.Retries
+	c.taskTimeout = c.roundConcurrency.taskTimeout()
+	c.taskBandwidth = c.roundConcurrency.TaskBandwidth * 1024
+	c.blobWorkers = c.roundConcurrency.BlobWorkers
+	c.registryLimiter = newRegistryLimiter(c.roundConcurrency.RegistryConnections)
+
+	c.imageReportsMu.Lock()
+	defer c.imageReportsMu.Unlock()
+	c.imageReports = make(map[string]*ImageReport)
+}
+
+// Progress 当前这一轮同步的进度，正在同步的destination按已经有结果的镜像计数
+func (c *Client) Progress() RunProgress {
+	c.imageReportsMu.Lock()
+	defer c.imageReportsMu.Unlock()
+	progress := c.progress
+	for _, report := range c.imageReports {
+		if report.Error != "" {
+			progress.ImagesFailed++
+		} else {
+			progress.ImagesSynced++
+		}
+	}
+	return progress
+}
+
+// resetProgress 开始新的一轮同步
+func (c *Client) resetProgress(runID string, destinations int) {
+	c.imageReportsMu.Lock()
+	defer c.imageReportsMu.Unlock()
+	c.progress = RunProgress{RunID: runID, Destinations: destinations}
+	c.imageReports = nil
+}
+
+// startProgress 开始同步一个destination
+func (c *Client) startProgress(current string) {
+	c.imageReportsMu.Lock()
+	defer c.imageReportsMu.Unlock()
+	c.progress.Current = current
+}
+
+// addProgressImages 对比完一个destination后记录需要同步的镜像数量
+func (c *Client) addProgressImages(images int) {
+	c.imageReportsMu.Lock()
+	defer c.imageReportsMu.Unlock()
+	c.progress.Images += images
+}
+
+// finishProgress 一个destination同步结束，把镜像的同步结果计入进度
+func (c *Client) finishProgress(summary *PairSummary) {
+	c.imageReportsMu.Lock()
+	defer c.imageReportsMu.Unlock()
+	for _, report := range summary.Images {
+		if report.Error != "" {
+			c.progress.ImagesFailed++
+		} else {
+			c.progress.ImagesSynced++
+		}
+	}
+	c.progress.DestinationsDone++
+	c.progress.Current = ""
+	c.imageReports = nil
+}
+
+// blobProgress 记录每个blob分块上传的进度
+func (c *Client) blobProgress(p sync.BlobProgress) {
+	c.imageReportsMu.Lock()
+	defer c.imageReportsMu.Unlock()
+	c.progress.BytesTransferred += p.Written
+	if p.Done {
+		c.Logger.Debugf("Blob %s uploaded, %d bytes", p.Digest, p.Offset)
+	} else {
+		c.Logger.Debugf("Blob %s uploading, %d/%d bytes", p.Digest, p.Offset, p.Size)
+	}
+}
+
+// putImageReport 记录一个镜像的同步结果，重试的结果会覆盖之前的结果
+func (c *Client) putImageReport(report *ImageReport) {
+	c.imageReportsMu.Lock()
+	defer c.imageReportsMu.Unlock()
+	c.imageReports[report.Source] = report
 }
 
 // GenerateSyncTask creates synchronization tasks from source and destination url, return URLPair array if there are more than one tags
@@ -470,7 +792,17 @@ func (c *Client) GenerateSyncTask(source string, destination string) ([]*URLPair
 		}
 	}
 
-	c.PutATask(sync.NewTask(imageSource, imageDestination, c.config.osFilterList, c.config.archFilterList, c.Logger))
+	task := sync.NewTask(imageSource, imageDestination, c.config.osFilterList, c.config.archFilterList, c.Logger)
+	task.SetTimeout(c.taskTimeout)
+	task.SetContext(c.roundCtx)
+	task.SetBandwidth(c.taskBandwidth)
+	task.SetProgress(c.blobProgress)
+	task.SetBlobWorkers(c.blobWorkers)
+	task.SetBlobGroup(c.blobGroup)
+	task.SetReferrers(c.Referrers)
+	task.SetArtifacts(c.Artifacts)
+	task.SetVerify(c.Verify)
+	c.PutATask(task)
 	c.Logger.Infof("Generate a task for %s to %s", sourceURL.GetURL(), destURL.GetURL())
 	return nil, nil
 }
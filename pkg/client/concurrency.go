@@ -0,0 +1,158 @@
+package client
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Concurrency 同步的并发设置，可以通过命令行参数、配置文件和http接口修改，修改后下一轮同步生效
+type Concurrency struct {
+	// 生成和执行同步任务的协程数量
+	Workers int `json:"workers" yaml:"workers"`
+	// 失败的同步任务重试的次数
+	Retries int `json:"retries" yaml:"retries"`
+	// 每个镜像仓库同时执行的同步任务数量，0 为不限制
+	RegistryConnections int `json:"registryConnections" yaml:"registryConnections"`
+	// 每个同步任务每次执行的超时时间，单位秒，0 为不限制
+	TaskTimeout int `json:"taskTimeout" yaml:"taskTimeout"`
+	// 每个同步任务同时传输的blob数量，0 和 1 都是逐个传输
+	BlobWorkers int `json:"blobWorkers" yaml:"blobWorkers"`
+	// 每个同步任务从主镜像仓库拉取blob的带宽限制，单位 KB/s，0 为不限制
+	TaskBandwidth int `json:"taskBandwidth" yaml:"taskBandwidth"`
+}
+
+// DefaultConcurrency 默认的并发设置
+var DefaultConcurrency = Concurrency{Workers: 5, Retries: 2, BlobWorkers: 3}
+
+// Validate 检查并发设置
+func (c Concurrency) Validate() error {
+	var errs []error
+	if c.Workers < 1 {
+		errs = append(errs, errors.New("workers should be at least 1"))
+	}
+	if c.Retries < 0 {
+		errs = append(errs, errors.New("retries should not be negative"))
+	}
+	if c.RegistryConnections < 0 {
+		errs = append(errs, errors.New("registryConnections should not be negative"))
+	}
+	if c.TaskTimeout < 0 {
+		errs = append(errs, errors.New("taskTimeout should not be negative"))
+	}
+	if c.BlobWorkers < 0 {
+		errs = append(errs, errors.New("blobWorkers should not be negative"))
+	}
+	if c.TaskBandwidth < 0 {
+		errs = append(errs, errors.New("taskBandwidth should not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// taskTimeout 每个同步任务的超时时间
+func (c Concurrency) taskTimeout() time.Duration {
+	return time.Duration(c.TaskTimeout) * time.Second
+}
+
+// ConcurrencyConfig 配置文件中的并发设置，没有填写的设置为nil，填写0时和命令行参数一样表示不限制或者不重试
+type ConcurrencyConfig struct {
+	Workers             *int `json:"workers" yaml:"workers"`
+	Retries             *int `json:"retries" yaml:"retries"`
+	RegistryConnections *int `json:"registryConnections" yaml:"registryConnections"`
+	TaskTimeout         *int `json:"taskTimeout" yaml:"taskTimeout"`
+	BlobWorkers         *int `json:"blobWorkers" yaml:"blobWorkers"`
+	TaskBandwidth       *int `json:"taskBandwidth" yaml:"taskBandwidth"`
+}
+
+// Override 使用配置文件中填写了的设置覆盖当前设置，没有填写的设置使用命令行参数
+func (c Concurrency) Override(o ConcurrencyConfig) Concurrency {
+	if o.Workers != nil {
+		c.Workers = *o.Workers
+	}
+	if o.Retries != nil {
+		c.Retries = *o.Retries
+	}
+	if o.RegistryConnections != nil {
+		c.RegistryConnections = *o.RegistryConnections
+	}
+	if o.TaskTimeout != nil {
+		c.TaskTimeout = *o.TaskTimeout
+	}
+	if o.BlobWorkers != nil {
+		c.BlobWorkers = *o.BlobWorkers
+	}
+	if o.TaskBandwidth != nil {
+		c.TaskBandwidth = *o.TaskBandwidth
+	}
+	return c
+}
+
+// Concurrency 当前的并发设置，没有设置时使用默认设置
+func (c *Client) Concurrency() Concurrency {
+	c.concurrencyMu.Lock()
+	defer c.concurrencyMu.Unlock()
+	if c.concurrency.Workers == 0 {
+		return DefaultConcurrency
+	}
+	return c.concurrency
+}
+
+// SetConcurrency 修改并发设置，正在执行的同步不受影响，下一轮同步生效
+func (c *Client) SetConcurrency(concurrency Concurrency) error {
+	if err := concurrency.Validate(); err != nil {
+		return err
+	}
+	c.concurrencyMu.Lock()
+	defer c.concurrencyMu.Unlock()
+	c.concurrency = concurrency
+	return nil
+}
+
+// registryLimiter 限制每个镜像仓库同时执行的同步任务数量
+type registryLimiter struct {
+	limit int
+
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// newRegistryLimiter creates a registryLimiter, limit 为0时不限制
+func newRegistryLimiter(limit int) *registryLimiter {
+	return &registryLimiter{limit: limit, slots: make(map[string]chan struct{})}
+}
+
+func (l *registryLimiter) slot(registry string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slot, ok := l.slots[registry]
+	if !ok {
+		slot = make(chan struct{}, l.limit)
+		l.slots[registry] = slot
+	}
+	return slot
+}
+
+// acquire 占用每个镜像仓库的一个位置，返回释放的函数
+// 按名称顺序占用，同一个镜像仓库只占用一次，避免两个任务互相等待
+func (l *registryLimiter) acquire(registries ...string) func() {
+	if l.limit <= 0 {
+		return func() {}
+	}
+	sorted := append([]string(nil), registries...)
+	sort.Strings(sorted)
+	var acquired []chan struct{}
+	for i, registry := range sorted {
+		if i > 0 && registry == sorted[i-1] {
+			continue
+		}
+		slot := l.slot(registry)
+		slot <- struct{}{}
+		acquired = append(acquired, slot)
+	}
+	return func() {
+		for _, slot := range acquired {
+			<-slot
+		}
+	}
+}
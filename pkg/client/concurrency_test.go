@@ -0,0 +1,69 @@
+package client
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrency(t *testing.T) {
+	assert.NoError(t, DefaultConcurrency.Validate())
+	err := Concurrency{Workers: 0, Retries: -1, RegistryConnections: -1, TaskTimeout: -1}.Validate()
+	assert.ErrorContains(t, err, "workers")
+	assert.ErrorContains(t, err, "retries")
+	assert.ErrorContains(t, err, "registryConnections")
+	assert.ErrorContains(t, err, "taskTimeout")
+
+	// 配置文件中没有填写的使用命令行参数
+	flags := Concurrency{Workers: 5, Retries: 2, TaskTimeout: 60}
+	workers, connections, zero := 10, 3, 0
+	assert.Equal(t, Concurrency{Workers: 10, Retries: 2, RegistryConnections: 3, TaskTimeout: 60},
+		flags.Override(ConcurrencyConfig{Workers: &workers, RegistryConnections: &connections}))
+	// 配置文件中填写0时覆盖命令行参数，不重试并且不限制超时时间
+	assert.Equal(t, Concurrency{Workers: 5, Retries: 0, TaskTimeout: 0},
+		flags.Override(ConcurrencyConfig{Retries: &zero, TaskTimeout: &zero}))
+	assert.Equal(t, time.Minute, flags.taskTimeout())
+
+	c := &Client{}
+	assert.Equal(t, DefaultConcurrency, c.Concurrency())
+	assert.Error(t, c.SetConcurrency(Concurrency{}))
+	assert.NoError(t, c.SetConcurrency(Concurrency{Workers: 8, Retries: 1}))
+	assert.Equal(t, Concurrency{Workers: 8, Retries: 1}, c.Concurrency())
+}
+
+func TestLoadSyncerConfigConcurrency(t *testing.T) {
+	config, err := LoadSyncerConfig(writeConfig(t, "sync.yaml", syncerConfigYaml+"concurrency:\n  workers: 10\n  retries: 0\n  taskTimeout: 600\n"))
+	if err != nil {
+		t.Fatalf("load config fail: %v", err)
+	}
+	workers, retries, taskTimeout := 10, 0, 600
+	assert.Equal(t, &ConcurrencyConfig{Workers: &workers, Retries: &retries, TaskTimeout: &taskTimeout}, config.Concurrency)
+	assert.Equal(t, Concurrency{Workers: 10, Retries: 0, TaskTimeout: 600, BlobWorkers: 3},
+		DefaultConcurrency.Override(*config.Concurrency))
+}
+
+func TestRegistryLimiter(t *testing.T) {
+	l := newRegistryLimiter(1)
+
+	release := l.acquire("prod-registry", "dev-registry")
+	var acquired atomic.Bool
+	go func() {
+		defer l.acquire("dev-registry", "other-registry")()
+		acquired.Store(true)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, acquired.Load(), "dev-registry should be full")
+
+	release()
+	assert.Eventually(t, acquired.Load, time.Second, time.Millisecond)
+
+	// 主从是同一个镜像仓库时只占用一次
+	newRegistryLimiter(1).acquire("registry", "registry")()
+
+	// 0 为不限制
+	unlimited := newRegistryLimiter(0)
+	unlimited.acquire("registry")
+	unlimited.acquire("registry")()
+}
@@ -32,8 +32,9 @@ type Auth struct {
 }
 
 // NewSyncConfig creates a Config struct
+// imageListMap 的key为从镜像仓库的 repo:tag，只有repo时同步这个repo所有的tag，sources 为从镜像仓库的 repo => 主镜像仓库的 repo，没有改写的repo可以不传
 // 需要开启：阿里云镜像仓库需要配置"仓库管理" => "访问控制" => "公网" => "访问入口 -> 开启" => "删除所有白名单后，公网下机器均可通过凭证访问企业版实例"
-func (api *AlibabacloudApi) NewSyncConfig(imageListMap map[string]string, osFilterList, archFilterList []string) (*Config, error) {
+func (api *AlibabacloudApi) NewSyncConfig(imageListMap map[string]string, sources map[string]string, osFilterList, archFilterList []string) (*Config, error) {
 	var config Config
 
 	// auth
@@ -42,24 +43,33 @@ func (api *AlibabacloudApi) NewSyncConfig(imageListMap map[string]string, osFilt
 	authList[*api.Master.Network] = Auth{
 		Username: *api.Master.Account,
 		Password: *api.Master.Password,
-		Insecure: false,
+		Insecure: api.Master.Insecure,
 	}
 	authList[*api.Slave.Network] = Auth{
 		Username: *api.Slave.Account,
 		Password: *api.Slave.Password,
-		Insecure: false,
+		Insecure: api.Slave.Insecure,
 	}
 
 	// images
 	// 这里的images是以{镜像:tag}的形式来保存的，比如{"alpine:v0.0.1":"v0.0.1"}
+	// 从镜像仓库的namespace和repo名称可以通过配置映射，默认和主镜像仓库一致
+	destNamespace := api.Config.DestNamespace(*api.RepoNamespaceName)
 	imageList := make(map[string]string)
-	for image, _ := range imageListMap {
-		realImage := strings.Split(image, ":")
-		imageList[*api.Master.Network+"/"+*api.RepoNamespaceName+"/"+image] = *api.Slave.Network + "/" + *api.RepoNamespaceName + "/" + realImage[0]
+	for image := range imageListMap {
+		realImage := strings.SplitN(image, ":", 2)
+		sourceImage := image
+		if source, ok := sources[realImage[0]]; ok {
+			sourceImage = source
+			if len(realImage) == 2 {
+				sourceImage += ":" + realImage[1]
+			}
+		}
+		imageList[*api.Master.Network+"/"+*api.RepoNamespaceName+"/"+sourceImage] = *api.Slave.Network + "/" + destNamespace + "/" + realImage[0]
 	}
 
-	config.defaultDestNamespace = "aliyun"
-	config.defaultDestRegistry = "test-registry.cn-shanghai.cr.aliyuncs.com"
+	config.defaultDestNamespace = api.Config.DefaultDestNamespace
+	config.defaultDestRegistry = api.Config.DefaultDestRegistry
 	config.osFilterList = osFilterList
 	config.archFilterList = archFilterList
 	config.AuthList = authList
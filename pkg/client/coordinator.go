@@ -0,0 +1,126 @@
+package client
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RunCoordinator 保证同一时间只有一轮同步在执行
+// 执行期间触发的同步排队，相同key的触发合并成一次，所有合并的调用方拿到同一个结果
+type RunCoordinator struct {
+	logger *logrus.Logger
+
+	mu      sync.Mutex
+	running bool
+	// 按触发顺序排队的同步
+	pending []*coordinatedRun
+}
+
+type coordinatedRun struct {
+	key string
+	fn  func(id string) *RunResult
+	// 开始执行时分配的id，也是这一轮同步报告的id，合并的调用方拿到同一个id
+	id string
+	// 排队期间合并进来的触发次数
+	coalesced int
+	// 轮到这一次同步执行时关闭
+	start chan struct{}
+	// 同步结束后关闭，result 只能在 done 关闭后读取
+	done   chan struct{}
+	result *RunResult
+}
+
+// NewRunCoordinator creates a RunCoordinator
+func NewRunCoordinator(logger *logrus.Logger) *RunCoordinator {
+	if logger == nil {
+		logger = logrus.New()
+	}
+	return &RunCoordinator{logger: logger}
+}
+
+// Do 执行一轮同步，已经有同步在执行时等待，key 相同的排队中的同步只执行一次
+// started 不为空时在这一轮同步开始执行时调用，参数为这一轮同步的id
+func (c *RunCoordinator) Do(key string, started func(id string), fn func(id string) *RunResult) *RunResult {
+	c.mu.Lock()
+	if !c.running {
+		c.running = true
+		c.mu.Unlock()
+		run := &coordinatedRun{key: key, fn: fn, id: newRunID(), done: make(chan struct{})}
+		notifyStarted(started, run)
+		c.execute(run)
+		return run.result
+	}
+
+	for _, run := range c.pending {
+		if run.key == key {
+			run.coalesced++
+			c.mu.Unlock()
+			<-run.start
+			notifyStarted(started, run)
+			<-run.done
+			return run.result
+		}
+	}
+	run := &coordinatedRun{key: key, fn: fn, start: make(chan struct{}), done: make(chan struct{})}
+	c.pending = append(c.pending, run)
+	c.mu.Unlock()
+
+	<-run.start
+	notifyStarted(started, run)
+	if run.coalesced > 0 {
+		c.logger.Infof("Sync %s coalesced %d triggers", key, run.coalesced)
+	}
+	c.execute(run)
+	return run.result
+}
+
+// Running 是否有同步正在执行
+func (c *RunCoordinator) Running() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.running
+}
+
+// execute 执行同步，panic 时也会释放锁并把错误作为结果返回
+func (c *RunCoordinator) execute(run *coordinatedRun) {
+	defer close(run.done)
+	defer c.release()
+	defer func() {
+		if r := recover(); r != nil {
+			c.logger.Errorf("Sync %s panic: %v\n%s", run.key, r, debug.Stack())
+			run.result = &RunResult{Msg: fmt.Sprintf("sync %s panic: %v", run.key, r)}
+		}
+	}()
+	run.result = run.fn(run.id)
+}
+
+// release 把锁交给下一个排队的同步
+func (c *RunCoordinator) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) == 0 {
+		c.running = false
+		return
+	}
+	next := c.pending[0]
+	c.pending = c.pending[1:]
+	// id 在 start 关闭前分配，等待 start 的调用方都能读到
+	next.id = newRunID()
+	close(next.start)
+}
+
+// notifyStarted 通知调用方这一轮同步已经开始执行，run.id 只能在开始执行后读取
+func notifyStarted(started func(id string), run *coordinatedRun) {
+	if started != nil {
+		started(run.id)
+	}
+}
+
+// newRunID 按开始时间生成同步的id，同时也是报告的文件名
+func newRunID() string {
+	return time.Now().UTC().Format(reportIDLayout)
+}
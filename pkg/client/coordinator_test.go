@@ -0,0 +1,70 @@
+package client
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunCoordinator(t *testing.T) {
+	c := NewRunCoordinator(nil)
+
+	release := make(chan struct{})
+	var runs atomic.Int32
+	run := func(msg string) func(id string) *RunResult {
+		return func(id string) *RunResult {
+			runs.Add(1)
+			<-release
+			return &RunResult{ID: id, Msg: msg}
+		}
+	}
+
+	first := make(chan *RunResult)
+	go func() { first <- c.Do("all", nil, run("first")) }()
+	assert.Eventually(t, func() bool { return runs.Load() == 1 }, time.Second, time.Millisecond)
+	assert.True(t, c.Running())
+
+	// 同步期间的触发合并成一次
+	var wg sync.WaitGroup
+	results := make([]*RunResult, 3)
+	startedIDs := make([]string, 3)
+	for i := range results {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.Do("all", func(id string) { startedIDs[i] = id }, run("follow-up"))
+		}()
+	}
+	assert.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.pending) == 1 && c.pending[0].coalesced == 2
+	}, time.Second, time.Millisecond)
+
+	close(release)
+	assert.Equal(t, "first", (<-first).Msg)
+	wg.Wait()
+	assert.Equal(t, int32(2), runs.Load())
+	// 合并的调用方拿到同一轮同步的id
+	for i, result := range results {
+		assert.Equal(t, "follow-up", result.Msg)
+		assert.NotEmpty(t, result.ID)
+		assert.Equal(t, result.ID, startedIDs[i])
+	}
+	assert.False(t, c.Running())
+}
+
+func TestRunCoordinatorPanic(t *testing.T) {
+	c := NewRunCoordinator(nil)
+	result := c.Do("all", nil, func(id string) *RunResult { panic("boom") })
+	assert.EqualError(t, result.Err(), "sync all panic: boom")
+	assert.False(t, c.Running())
+
+	// panic 之后锁已经释放
+	result = c.Do("all", nil, func(id string) *RunResult { return &RunResult{ID: id, Msg: "success"} })
+	assert.NoError(t, result.Err())
+}
@@ -0,0 +1,199 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// JobState 同步任务的状态
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+)
+
+const (
+	// DefaultJobQueueSize 默认最多可以排队的同步任务数量
+	DefaultJobQueueSize = 10
+	// DefaultMaxJobs 默认在内存中保留的同步任务数量
+	DefaultMaxJobs = 100
+)
+
+var (
+	// ErrJobQueueFull 排队的同步任务太多
+	ErrJobQueueFull = errors.New("too many queued sync jobs, please try again later")
+	// ErrNotLeader 多副本部署时只有leader执行同步
+	ErrNotLeader = errors.New("this replica is not the leader, please try again later")
+	// ErrLostLeader 同步过程中失去leader，剩下的镜像不再同步
+	ErrLostLeader = errors.New("this replica lost the leadership during the sync, the remaining images are not synced")
+)
+
+// Job 一次通过http接口触发的异步同步
+type Job struct {
+	ID    string   `json:"id"`
+	State JobState `json:"state"`
+	// 定向同步的目标，为空时同步所有的主从
+	Target     *SyncTarget `json:"target,omitempty"`
+	CreatedAt  time.Time   `json:"createdAt"`
+	StartedAt  *time.Time  `json:"startedAt,omitempty"`
+	FinishedAt *time.Time  `json:"finishedAt,omitempty"`
+	// 执行这个任务的一轮同步的id，开始执行后才有，合并的任务共用同一轮同步
+	RunID string `json:"runId,omitempty"`
+	// 运行中是这一轮同步的实时进度，结束后是最终结果
+	Progress RunProgress `json:"progress"`
+	Error    string      `json:"error,omitempty"`
+	// 每个镜像的同步结果，结束后才有
+	Result *RunResult `json:"result,omitempty"`
+}
+
+// JobRunner 执行一轮同步，Client 实现了这个接口
+type JobRunner interface {
+	// RunJob target 为空时同步所有的主从，started 在这一轮同步开始执行时调用
+	RunJob(target *SyncTarget, started func(runID string)) *RunResult
+	// Progress 当前正在执行或者最后一轮同步的进度，可能是轮询触发的同步
+	Progress() RunProgress
+}
+
+// JobManager 同步任务排队依次执行，只在内存中保留最近的 maxJobs 个任务
+type JobManager struct {
+	runner  JobRunner
+	maxJobs int
+	queue   chan *Job
+
+	mu sync.Mutex
+	// 按创建时间排序
+	jobs    []*Job
+	running *Job
+}
+
+// NewJobManager creates a JobManager, queueSize 为最多可以排队的任务数量
+func NewJobManager(runner JobRunner, queueSize, maxJobs int) *JobManager {
+	return &JobManager{
+		runner:  runner,
+		maxJobs: maxJobs,
+		queue:   make(chan *Job, queueSize),
+	}
+}
+
+// Start 依次执行排队的任务，直到 ctx 结束
+func (m *JobManager) Start(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-m.queue:
+			m.run(job)
+		}
+	}
+}
+
+func (m *JobManager) run(job *Job) {
+	now := time.Now()
+	m.mu.Lock()
+	job.State = JobRunning
+	job.StartedAt = &now
+	m.running = job
+	m.mu.Unlock()
+
+	// 排队等待轮询的同步时不知道是哪一轮，开始执行后才记录id
+	result := m.runner.RunJob(job.Target, func(runID string) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		job.RunID = runID
+	})
+
+	finished := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running = nil
+	job.FinishedAt = &finished
+	if result.Progress != nil {
+		job.Progress = *result.Progress
+	}
+	job.Result = result
+	if err := result.Err(); err != nil {
+		job.State = JobFailed
+		job.Error = err.Error()
+	} else {
+		job.State = JobSucceeded
+	}
+	m.prune()
+}
+
+// Submit 创建一个排队的同步任务，target 为空时同步所有的主从
+func (m *JobManager) Submit(target *SyncTarget) (*Job, error) {
+	job := &Job{ID: newJobID(), State: JobQueued, Target: target, CreatedAt: time.Now()}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case m.queue <- job:
+	default:
+		return nil, ErrJobQueueFull
+	}
+	m.jobs = append(m.jobs, job)
+	m.prune()
+	return m.snapshot(job), nil
+}
+
+// prune 超过 maxJobs 时删除最早结束的任务，排队和运行中的任务不删除
+func (m *JobManager) prune() {
+	for i := 0; len(m.jobs) > m.maxJobs && i < len(m.jobs); {
+		if m.jobs[i].FinishedAt == nil {
+			i++
+			continue
+		}
+		m.jobs = append(m.jobs[:i], m.jobs[i+1:]...)
+	}
+}
+
+// Get 查询一个同步任务
+func (m *JobManager) Get(id string) (*Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, job := range m.jobs {
+		if job.ID == id {
+			return m.snapshot(job), true
+		}
+	}
+	return nil, false
+}
+
+// List 所有的同步任务，最新的在前面，不包含每个镜像的同步结果
+func (m *JobManager) List() []*Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]*Job, 0, len(m.jobs))
+	for i := len(m.jobs) - 1; i >= 0; i-- {
+		job := m.snapshot(m.jobs[i])
+		job.Result = nil
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// snapshot 复制一份任务，运行中的任务使用实时进度，正在执行的是其它同步时不使用
+func (m *JobManager) snapshot(job *Job) *Job {
+	copied := *job
+	if job == m.running && job.RunID != "" {
+		if progress := m.runner.Progress(); progress.RunID == job.RunID {
+			copied.Progress = progress
+		}
+	}
+	return &copied
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().UTC().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeRunner struct {
+	// 排队等待正在执行的其它同步
+	start   chan struct{}
+	release chan struct{}
+	results []*RunResult
+
+	mu       sync.Mutex
+	progress RunProgress
+}
+
+func (r *fakeRunner) RunJob(target *SyncTarget, started func(runID string)) *RunResult {
+	result := r.results[0]
+	r.results = r.results[1:]
+	<-r.start
+	r.setProgress(RunProgress{RunID: result.ID, Destinations: 1})
+	started(result.ID)
+	<-r.release
+	result.Progress = &RunProgress{RunID: result.ID, Destinations: 1, DestinationsDone: 1}
+	if target != nil {
+		result.Msg = target.String()
+	}
+	return result
+}
+
+func (r *fakeRunner) setProgress(progress RunProgress) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.progress = progress
+}
+
+func (r *fakeRunner) Progress() RunProgress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.progress
+}
+
+func waitJobState(t *testing.T, m *JobManager, id string, state JobState) *Job {
+	var job *Job
+	assert.Eventually(t, func() bool {
+		job, _ = m.Get(id)
+		return job.State == state
+	}, time.Second, time.Millisecond)
+	return job
+}
+
+func TestJobManager(t *testing.T) {
+	runner := &fakeRunner{
+		start:   make(chan struct{}),
+		release: make(chan struct{}),
+		results: []*RunResult{
+			{ID: "1", Msg: "success", Pairs: []*PairSummary{{Destination: "prod"}}},
+			{ID: "2", Msg: "success", Pairs: []*PairSummary{{Destination: "prod", FailedTasks: 1}}},
+			{ID: "3", Msg: "success"},
+		},
+	}
+	m := NewJobManager(runner, 1, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Start(ctx)
+
+	first, err := m.Submit(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, JobQueued, first.State)
+	// 轮询的同步正在执行，不是这个任务的进度
+	runner.setProgress(RunProgress{RunID: "polling", Destinations: 5})
+	job := waitJobState(t, m, first.ID, JobRunning)
+	assert.Empty(t, job.RunID)
+	assert.Equal(t, RunProgress{}, job.Progress)
+
+	runner.start <- struct{}{}
+	assert.Eventually(t, func() bool {
+		job, _ = m.Get(first.ID)
+		return job.RunID == "1"
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, RunProgress{RunID: "1", Destinations: 1}, job.Progress)
+
+	// 第一个任务运行中，第二个排队，队列满了
+	second, err := m.Submit(nil)
+	assert.NoError(t, err)
+	_, err = m.Submit(nil)
+	assert.ErrorIs(t, err, ErrJobQueueFull)
+
+	runner.release <- struct{}{}
+	job = waitJobState(t, m, first.ID, JobSucceeded)
+	assert.Equal(t, "1", job.Result.ID)
+	assert.Equal(t, RunProgress{RunID: "1", Destinations: 1, DestinationsDone: 1}, job.Progress)
+
+	// 第二个任务开始前其它同步的进度不算在它上面
+	runner.setProgress(RunProgress{RunID: "polling", Destinations: 5})
+	job = waitJobState(t, m, second.ID, JobRunning)
+	assert.Equal(t, RunProgress{}, job.Progress)
+	runner.start <- struct{}{}
+	runner.release <- struct{}{}
+	job = waitJobState(t, m, second.ID, JobFailed)
+	assert.Equal(t, "1 of 1 destinations failed to sync", job.Error)
+
+	// 定向同步
+	target, err := m.Submit(&SyncTarget{Namespace: "one", Repo: "alix", Tag: "v0.0.1"})
+	assert.NoError(t, err)
+	runner.start <- struct{}{}
+	runner.release <- struct{}{}
+	job = waitJobState(t, m, target.ID, JobSucceeded)
+	assert.Equal(t, "one/alix:v0.0.1", job.Result.Msg)
+
+	// 只保留最近的两个任务，列表中不包含同步结果
+	_, ok := m.Get(first.ID)
+	assert.False(t, ok)
+	jobs := m.List()
+	assert.Len(t, jobs, 2)
+	assert.Equal(t, target.ID, jobs[0].ID)
+	assert.Equal(t, second.ID, jobs[1].ID)
+	assert.Nil(t, jobs[1].Result)
+}
+
+func TestRunResultErr(t *testing.T) {
+	assert.EqualError(t, (&RunResult{Msg: "namespace three is not configured in any pair"}).Err(), "namespace three is not configured in any pair")
+	assert.NoError(t, (&RunResult{ID: "1", Msg: "success", Pairs: []*PairSummary{{}}}).Err())
+	assert.Error(t, (&RunResult{ID: "1", Msg: "success", Pairs: []*PairSummary{{Error: "list fail"}}}).Err())
+}
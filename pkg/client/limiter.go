@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"aliyun-images-syncer/util/waitutil"
+
+	"github.com/alibabacloud-go/tea/tea"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DefaultQPS 阿里云镜像api免费版本 qps 20，默认留一些余量
+	DefaultQPS = 15
+	// DefaultBurst 令牌桶默认容量
+	DefaultBurst = 5
+)
+
+// ApiLimiter 所有 cr20181201.Client 调用共用的令牌桶，按 实例 + api 区分，
+// 同一个实例被多组主从使用时共用同一个令牌桶
+type ApiLimiter struct {
+	mu       sync.Mutex
+	limiters map[string]*apiBucket
+	// 触发限流后的退避策略
+	backoff waitutil.Backoff
+}
+
+type apiBucket struct {
+	limiter *rate.Limiter
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+}
+
+// NewApiLimiter creates an ApiLimiter
+func NewApiLimiter() *ApiLimiter {
+	return &ApiLimiter{
+		limiters: make(map[string]*apiBucket),
+		backoff: waitutil.Backoff{
+			Duration: time.Second,
+			Factor:   2,
+			Jitter:   0.2,
+			Steps:    4,
+			Cap:      10 * time.Second,
+		},
+	}
+}
+
+// bucket 获取实例和api对应的令牌桶，qps 小于等于0 时使用默认值
+func (l *ApiLimiter) bucket(instanceId, api string, qps float64, burst int) *apiBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := instanceId + "/" + api
+	if b, ok := l.limiters[key]; ok {
+		return b
+	}
+	if qps <= 0 {
+		qps = DefaultQPS
+	}
+	if burst <= 0 {
+		burst = DefaultBurst
+	}
+	b := &apiBucket{limiter: rate.NewLimiter(rate.Limit(qps), burst)}
+	l.limiters[key] = b
+	return b
+}
+
+// wait 等待令牌，桶被限流暂停时先等暂停结束
+func (b *apiBucket) wait(ctx context.Context) error {
+	b.mu.Lock()
+	pause := time.Until(b.pausedUntil)
+	b.mu.Unlock()
+	if pause > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pause):
+		}
+	}
+	return b.limiter.Wait(ctx)
+}
+
+// pause 触发限流后暂停整个桶，其它协程也会一起退避
+func (b *apiBucket) pause(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if until := time.Now().Add(d); until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+}
+
+// Do 在令牌桶的控制下执行一次api调用，遇到限流错误时退避后重试
+func (l *ApiLimiter) Do(ctx context.Context, registry *Alibabacloud, api string, call func() error) error {
+	b := l.bucket(tea.StringValue(registry.InstanceId), api, registry.QPS, registry.Burst)
+	backoff := l.backoff
+	for {
+		if err := b.wait(ctx); err != nil {
+			return err
+		}
+		err := call()
+		if err == nil || !IsThrottlingError(err) || backoff.Steps <= 0 {
+			return err
+		}
+		b.pause(backoff.Step())
+	}
+}
+
+// IsThrottlingError 是否是阿里云openapi的限流错误
+func IsThrottlingError(err error) bool {
+	var sdkError *tea.SDKError
+	if !errors.As(err, &sdkError) {
+		return false
+	}
+	if tea.IntValue(sdkError.StatusCode) == 429 {
+		return true
+	}
+	// Throttling / Throttling.User / Throttling.Api / Throttling.Tenant ...
+	return strings.HasPrefix(tea.StringValue(sdkError.Code), "Throttling")
+}
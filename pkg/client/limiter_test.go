@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"aliyun-images-syncer/util/waitutil"
+
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsThrottlingError(t *testing.T) {
+	assert.True(t, IsThrottlingError(&tea.SDKError{Code: tea.String("Throttling.User")}))
+	assert.True(t, IsThrottlingError(&tea.SDKError{StatusCode: tea.Int(429)}))
+	assert.False(t, IsThrottlingError(&tea.SDKError{Code: tea.String("InvalidParameter")}))
+	assert.False(t, IsThrottlingError(errors.New("Throttling")))
+}
+
+func TestApiLimiterDo(t *testing.T) {
+	limiter := NewApiLimiter()
+	limiter.backoff = waitutil.Backoff{Duration: time.Millisecond, Factor: 2, Steps: 2}
+	registry := &Alibabacloud{InstanceId: tea.String("cri-test"), QPS: 1000, Burst: 10}
+
+	// 限流错误退避后重试
+	calls := 0
+	err := limiter.Do(context.Background(), registry, "ListRepoTag", func() error {
+		calls++
+		if calls < 2 {
+			return &tea.SDKError{Code: tea.String("Throttling")}
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, calls)
+
+	// 重试次数用完返回最后一次的错误
+	calls = 0
+	err = limiter.Do(context.Background(), registry, "ListRepoTag", func() error {
+		calls++
+		return &tea.SDKError{Code: tea.String("Throttling")}
+	})
+	assert.True(t, IsThrottlingError(err))
+	assert.Equal(t, 3, calls)
+
+	// 其它错误不重试
+	calls = 0
+	err = limiter.Do(context.Background(), registry, "ListRepository", func() error {
+		calls++
+		return errors.New("boom")
+	})
+	assert.EqualError(t, err, "boom")
+	assert.Equal(t, 1, calls)
+
+	// 同一个实例同一个api共用令牌桶
+	assert.Equal(t, limiter.bucket("cri-test", "ListRepoTag", 0, 0), limiter.bucket("cri-test", "ListRepoTag", 0, 0))
+}
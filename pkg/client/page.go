@@ -0,0 +1,63 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// PartialListError 分页拉取中途失败，只拿到了部分数据
+type PartialListError struct {
+	// 拉取的内容，比如 repositories of ns
+	What    string
+	Fetched int
+	Total   int
+	Err     error
+}
+
+func (e *PartialListError) Error() string {
+	return fmt.Sprintf("partial listing of %s, fetched %d of %d: %v", e.What, e.Fetched, e.Total, e.Err)
+}
+
+func (e *PartialListError) Unwrap() error {
+	return e.Err
+}
+
+// listAllPages 从第一页开始翻页，直到拉完 TotalCount 或者某一页为空/不满 pageSize
+// fetch 返回当前页的数据和接口返回的 TotalCount；第一页失败直接返回错误，后面的页失败返回已经拉到的数据和 PartialListError
+func listAllPages[T any](what string, pageSize int32, fetch func(pageNo int32) ([]T, string, error)) ([]T, error) {
+	var (
+		all   []T
+		total int
+	)
+	for pageNo := int32(1); ; pageNo++ {
+		page, totalCount, err := fetch(pageNo)
+		if err != nil {
+			if pageNo == 1 {
+				return nil, err
+			}
+			return all, &PartialListError{What: what, Fetched: len(all), Total: total, Err: err}
+		}
+		all = append(all, page...)
+
+		if len(page) == 0 {
+			break
+		}
+		// 优先使用 TotalCount 判断，服务端可能会限制每页的最大数量，TotalCount 解析失败时只能依赖当前页是否满页来判断
+		if n, err := strconv.Atoi(totalCount); err == nil && n > 0 {
+			total = n
+			if len(all) >= total {
+				break
+			}
+			continue
+		}
+		if len(page) < int(pageSize) {
+			break
+		}
+	}
+
+	if total > len(all) {
+		return all, &PartialListError{What: what, Fetched: len(all), Total: total,
+			Err: fmt.Errorf("got an empty page but TotalCount is %d", total)}
+	}
+	return all, nil
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListAllPages(t *testing.T) {
+	data := make([]int, 25)
+	for i := range data {
+		data[i] = i
+	}
+	// 服务端每页最多返回10条
+	fetch := func(failPage int32) func(pageNo int32) ([]int, string, error) {
+		return func(pageNo int32) ([]int, string, error) {
+			if pageNo == failPage {
+				return nil, "", errors.New("throttled")
+			}
+			start := int(pageNo-1) * 10
+			if start >= len(data) {
+				return nil, strconv.Itoa(len(data)), nil
+			}
+			end := start + 10
+			if end > len(data) {
+				end = len(data)
+			}
+			return data[start:end], strconv.Itoa(len(data)), nil
+		}
+	}
+
+	all, err := listAllPages("numbers", 1000, fetch(0))
+	assert.Nil(t, err)
+	assert.Equal(t, data, all)
+
+	_, err = listAllPages("numbers", 1000, fetch(1))
+	assert.EqualError(t, err, "throttled")
+
+	all, err = listAllPages("numbers", 1000, fetch(3))
+	var partial *PartialListError
+	if !errors.As(err, &partial) {
+		t.Fatalf("should be a partial listing, now is -> %v", err)
+	}
+	assert.Equal(t, 20, partial.Fetched)
+	assert.Equal(t, 25, partial.Total)
+	assert.Equal(t, 20, len(all))
+}
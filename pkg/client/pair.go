@@ -0,0 +1,108 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"aliyun-images-syncer/pkg/tools"
+)
+
+// SyncPair 一组主从，一个主镜像仓库同步到多个从镜像仓库
+type SyncPair struct {
+	Name   string
+	Config *PairConfig
+	// 每个从镜像仓库对应一个api，主镜像仓库共用同一个 Alibabacloud
+	Apis []*AlibabacloudApi
+	// 轮询间隔
+	Polling time.Duration
+
+	lastRun time.Time
+}
+
+// due 距离上一次同步是否已经超过轮询间隔
+func (p *SyncPair) due(now time.Time) bool {
+	return p.lastRun.IsZero() || now.Sub(p.lastRun) >= p.Polling
+}
+
+// PairSummary 一组主从中一个从镜像仓库一个namespace的同步结果
+type PairSummary struct {
+	Pair        string `json:"pair"`
+	Destination string `json:"destination"`
+	Namespace   string `json:"namespace"`
+
+	// 需要同步的 repo:tag 数量，Missing 包含从镜像仓库整个repo都不存在的tag
+	Missing     int `json:"missing"`
+	DigestDrift int `json:"digestDrift"`
+	// 从镜像仓库不存在的repo数量
+	MissingRepos int `json:"missingRepos"`
+	// digest 不一致但是上次同步后主从都没有变化，跳过同步的数量
+	Verified int `json:"verified,omitempty"`
+	// 按os/arch过滤后推送的manifest list数量，这些镜像主从digest不一致
+	Filtered int `json:"filtered,omitempty"`
+
+	FailedTasks    int    `json:"failedTasks"`
+	FailedGenerate int    `json:"failedGenerate"`
+	Error          string `json:"error,omitempty"`
+	// 重试后仍然拉取tag失败的repo，<镜像仓库>/<repo> => 失败原因，这些repo本轮跳过
+	ListFailures map[string]string `json:"listFailures,omitempty"`
+	// 每个需要同步的镜像的同步结果
+	Images []*ImageReport `json:"images,omitempty"`
+}
+
+// addListFailures 记录拉取tag失败的repo
+func (s *PairSummary) addListFailures(registry string, repoTagsMap tools.RepoTagsMap) {
+	for repo, reason := range repoTagsMap.Failures() {
+		if s.ListFailures == nil {
+			s.ListFailures = make(map[string]string)
+		}
+		s.ListFailures[registry+"/"+repo] = reason
+	}
+}
+
+// RunResult 一轮同步的结果
+type RunResult struct {
+	// 开始时间，报告保存的文件名
+	ID         string    `json:"id,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Msg        string    `json:"msg"`
+	// 只是同步计划，没有向从镜像仓库写入数据
+	DryRun bool           `json:"dryRun,omitempty"`
+	Pairs  []*PairSummary `json:"pairs"`
+	// 同步结束时的进度，没有执行同步时为空
+	Progress *RunProgress `json:"progress,omitempty"`
+}
+
+// Err 一轮同步中出现的错误，没有错误时返回nil
+func (r *RunResult) Err() error {
+	if r.ID == "" {
+		// 没有执行同步，比如同步的目标不存在或者同步中panic
+		return fmt.Errorf("%s", r.Msg)
+	}
+	var failed int
+	for _, summary := range r.Pairs {
+		if summary.Error != "" || summary.FailedTasks > 0 || summary.FailedGenerate > 0 {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d destinations failed to sync", failed, len(r.Pairs))
+	}
+	return nil
+}
+
+// RunProgress 一轮同步的进度，每个从镜像仓库的每个namespace算一个destination
+type RunProgress struct {
+	// 这一轮同步的id，和报告的id相同，还没有同步过时为空
+	RunID            string `json:"runId,omitempty"`
+	Destinations     int    `json:"destinations"`
+	DestinationsDone int    `json:"destinationsDone"`
+	// 已经发现的需要同步的镜像数量，后面的destination对比完之后才会增加
+	Images       int `json:"images"`
+	ImagesSynced int `json:"imagesSynced"`
+	ImagesFailed int `json:"imagesFailed"`
+	// 这一轮已经上传到从镜像仓库的字节数，包括还没有同步完成的blob
+	BytesTransferred int64 `json:"bytesTransferred"`
+	// 正在同步的 <主从>/<从镜像仓库>/<namespace>
+	Current string `json:"current,omitempty"`
+}
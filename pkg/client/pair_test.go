@@ -0,0 +1,33 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunDuePolling(t *testing.T) {
+	fast := &SyncPair{Name: "fast", Config: &PairConfig{}, Polling: time.Minute}
+	slow := &SyncPair{Name: "slow", Config: &PairConfig{}, Polling: 5 * time.Minute}
+	c := &Client{Logger: logrus.New(), pairs: []*SyncPair{fast, slow}}
+
+	tick := time.Date(2023, 11, 17, 8, 0, 0, 0, time.UTC)
+	c.run("1", tick, func(pair *SyncPair) bool { return pair.due(tick) }, nil)
+	// 按触发的时间记录，轮询间隔等于ticker间隔时下一次tick仍然同步
+	assert.Equal(t, tick, fast.lastRun)
+	assert.Equal(t, tick, slow.lastRun)
+
+	tick = tick.Add(time.Minute)
+	assert.True(t, fast.due(tick))
+	assert.False(t, slow.due(tick))
+	c.run("2", tick, func(pair *SyncPair) bool { return pair.due(tick) }, nil)
+	assert.Equal(t, tick, fast.lastRun)
+	assert.Equal(t, tick.Add(-time.Minute), slow.lastRun)
+
+	// 定向同步不影响轮询间隔
+	target := &SyncTarget{Namespace: "apps"}
+	c.run("3", tick.Add(time.Second), target.match, target)
+	assert.Equal(t, tick, fast.lastRun)
+}
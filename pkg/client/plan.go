@@ -0,0 +1,58 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// WritePlan 按从镜像仓库输出 dry run 的同步计划：每个镜像需要传输的blob数量和预估的字节数
+func WritePlan(w io.Writer, result *RunResult) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	var images, blobs, failed int
+	var bytes int64
+	for _, summary := range result.Pairs {
+		fmt.Fprintf(tw, "%s => %s, namespace %s: %d missing (%d repos missing), %d digest drift, %d verified\n",
+			summary.Pair, summary.Destination, summary.Namespace,
+			summary.Missing, summary.MissingRepos, summary.DigestDrift, summary.Verified)
+		if summary.Error != "" {
+			fmt.Fprintf(tw, "  error: %s\n", summary.Error)
+		}
+		for repo, reason := range summary.ListFailures {
+			fmt.Fprintf(tw, "  skipped %s: %s\n", repo, reason)
+		}
+		for _, image := range summary.Images {
+			if image.Error != "" {
+				failed++
+				fmt.Fprintf(tw, "  %s\t%s\t=> %s\terror: %s\n", image.Reason, image.Source, image.Destination, image.Error)
+				continue
+			}
+			images++
+			blobs += image.BlobsTransferred
+			bytes += image.BytesTransferred
+			fmt.Fprintf(tw, "  %s\t%s\t=> %s\t%d manifests, %d blobs (%s) to transfer, %d blobs exist\n",
+				image.Reason, image.Source, image.Destination, image.Manifests,
+				image.BlobsTransferred, humanBytes(image.BytesTransferred), image.BlobsSkipped)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "Total: %d images, %d blobs (%s) to transfer, %d images failed to plan\n",
+		images, blobs, humanBytes(bytes), failed)
+	return err
+}
+
+// humanBytes 把字节数转换成 KB/MB/GB
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
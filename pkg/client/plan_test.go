@@ -0,0 +1,34 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWritePlan(t *testing.T) {
+	result := &RunResult{DryRun: true, Pairs: []*PairSummary{{
+		Pair:        DefaultPairName,
+		Destination: "prod",
+		Namespace:   "one",
+		Missing:     1,
+		DigestDrift: 1,
+		Images: []*ImageReport{
+			{Reason: "missing", Source: "dev/one/alix:v0.0.1", Destination: "prod/one/alix:v0.0.1", Manifests: 1, BlobsTransferred: 3, BytesTransferred: 3 << 20, BlobsSkipped: 1},
+			{Reason: "digest drift", Source: "dev/one/alix:latest", Destination: "prod/one/alix:latest", Error: "unauthorized"},
+		},
+	}}}
+
+	var b strings.Builder
+	assert.NoError(t, WritePlan(&b, result))
+	assert.Equal(t, `default => prod, namespace one: 1 missing (0 repos missing), 1 digest drift, 0 verified
+  missing       dev/one/alix:v0.0.1  => prod/one/alix:v0.0.1  1 manifests, 3 blobs (3.0 MiB) to transfer, 1 blobs exist
+  digest drift  dev/one/alix:latest  => prod/one/alix:latest  error: unauthorized
+Total: 1 images, 3 blobs (3.0 MiB) to transfer, 1 images failed to plan
+`, b.String())
+
+	assert.Equal(t, "512 B", humanBytes(512))
+	assert.Equal(t, "1.5 KiB", humanBytes(1536))
+	assert.Equal(t, "2.0 GiB", humanBytes(2<<30))
+}
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"aliyun-images-syncer/pkg/sync"
+	"aliyun-images-syncer/pkg/tools"
+)
+
+// reportIDLayout 每一轮同步报告的id为开始时间，同时也是报告的文件名
+const reportIDLayout = "20060102T150405.000Z"
+
+// ImageReport 一个镜像 repo:tag 的同步结果
+type ImageReport struct {
+	Namespace string `json:"namespace"`
+	// 从镜像仓库的repo名称
+	Repo string `json:"repo"`
+	Tag  string `json:"tag"`
+	// 需要同步的原因 missing/repo missing/digest drift
+	Reason      string `json:"reason"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+
+	// 主镜像仓库manifest的digest和推送到从镜像仓库的digest，按os/arch过滤过的manifest list会不一致
+	SourceDigest      string `json:"sourceDigest,omitempty"`
+	DestinationDigest string `json:"destinationDigest,omitempty"`
+
+	// 按os/arch过滤后的manifest数量
+	Manifests int `json:"manifests"`
+	// manifest list中按os/arch过滤掉的平台，比如 linux/arm/v7，不为空时主从digest不一致
+	FilteredPlatforms []string `json:"filteredPlatforms,omitempty"`
+	// dry run 时为需要传输的blob数量和预估的字节数
+	BytesTransferred int64 `json:"bytesTransferred"`
+	BlobsTransferred int   `json:"blobsTransferred"`
+	// 从镜像仓库已经存在，跳过的blob数量
+	BlobsSkipped int `json:"blobsSkipped"`
+	// 同步的签名、SBOM和attestation数量
+	Referrers int `json:"referrers,omitempty"`
+	// 非镜像制品的artifactType，比如Helm chart
+	ArtifactType string `json:"artifactType,omitempty"`
+	// 同步后从镜像仓库的manifest和blob校验通过
+	DestinationVerified bool   `json:"destinationVerified,omitempty"`
+	Duration            string `json:"duration"`
+	Error               string `json:"error,omitempty"`
+}
+
+// newTaskReport 根据同步任务最后一次执行的结果生成报告
+func newTaskReport(task *sync.Task, err error) *ImageReport {
+	source, destination := task.GetSource(), task.GetDestination()
+	stats := task.Stats()
+	report := &ImageReport{
+		Source:              source.GetRegistry() + "/" + source.GetRepository() + ":" + source.GetTag(),
+		Destination:         destination.GetRegistry() + "/" + destination.GetRepository() + ":" + destination.GetTag(),
+		SourceDigest:        stats.SourceDigest,
+		DestinationDigest:   stats.DestinationDigest,
+		Manifests:           stats.Manifests,
+		FilteredPlatforms:   stats.FilteredPlatforms,
+		BytesTransferred:    stats.BytesTransferred,
+		BlobsTransferred:    stats.BlobsTransferred,
+		BlobsSkipped:        stats.BlobsSkipped,
+		Referrers:           stats.Referrers,
+		ArtifactType:        stats.ArtifactType,
+		DestinationVerified: stats.DestinationVerified,
+		Duration:            stats.Duration.String(),
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	return report
+}
+
+// complete 补充namespace、repo、tag和同步原因，syncMap 为从镜像仓库的 repo:tag => 需要同步的原因
+func (r *ImageReport) complete(namespace string, syncMap map[string]string) {
+	r.Namespace = namespace
+	// 生成任务失败时 destination 没有tag，使用 source 的tag
+	if sourceURL, err := tools.NewRepoURL(r.Source); err == nil {
+		r.Tag = sourceURL.GetTag()
+	}
+	if destURL, err := tools.NewRepoURL(r.Destination); err == nil {
+		r.Repo = destURL.GetRepo()
+		if destURL.GetTag() != "" {
+			r.Tag = destURL.GetTag()
+		}
+	}
+	r.Reason = syncMap[r.Repo+":"+r.Tag]
+	if r.Reason == "" {
+		// 同步整个repo时 syncMap 的key只有repo
+		r.Reason = syncMap[r.Repo]
+	}
+}
+
+// ReportStore 把每一轮同步的结果以json的格式保存到目录中，方便审计什么时候同步了哪些镜像
+type ReportStore struct {
+	dir string
+}
+
+// NewReportStore creates a ReportStore, dir 为空时不保存报告
+func NewReportStore(dir string) (*ReportStore, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("create report dir %s error: %v", dir, err)
+		}
+	}
+	return &ReportStore{dir: dir}, nil
+}
+
+// Enabled 是否配置了报告目录
+func (s *ReportStore) Enabled() bool {
+	return s != nil && s.dir != ""
+}
+
+// Save 保存一轮同步的结果，先写临时文件再改名，避免读到写了一半的报告
+func (s *ReportStore) Save(result *RunResult) error {
+	if !s.Enabled() {
+		return nil
+	}
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, result.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Get 读取一轮同步的结果
+func (s *ReportStore) Get(id string) (*RunResult, error) {
+	if !s.Enabled() {
+		return nil, os.ErrNotExist
+	}
+	if _, err := time.Parse(reportIDLayout, id); err != nil {
+		return nil, fmt.Errorf("invalid report id %q", id)
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, id+".json"))
+	if err != nil {
+		return nil, err
+	}
+	result := &RunResult{}
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// List 返回所有报告的id，最新的在前面
+func (s *ReportStore) List() ([]string, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(reportIDLayout, id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	return ids, nil
+}
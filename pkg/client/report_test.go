@@ -0,0 +1,78 @@
+package client
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportStore(t *testing.T) {
+	store, err := NewReportStore(t.TempDir())
+	assert.NoError(t, err)
+
+	startedAt := time.Date(2023, 11, 17, 8, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		result := &RunResult{
+			ID:        startedAt.Add(time.Duration(i) * time.Minute).Format(reportIDLayout),
+			StartedAt: startedAt,
+			Msg:       "success",
+			Pairs: []*PairSummary{{
+				Pair:      DefaultPairName,
+				Namespace: "one",
+				Images: []*ImageReport{{
+					Repo:             "alix",
+					Tag:              "v0.0.1",
+					Reason:           "missing",
+					BytesTransferred: 1024,
+				}},
+			}},
+		}
+		assert.NoError(t, store.Save(result))
+	}
+
+	ids, err := store.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"20231117T080100.000Z", "20231117T080000.000Z"}, ids)
+
+	result, err := store.Get(ids[0])
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1024), result.Pairs[0].Images[0].BytesTransferred)
+
+	_, err = store.Get("20231117T090000.000Z")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = store.Get("../config")
+	assert.Error(t, err)
+
+	// 没有配置目录时不保存
+	disabled, err := NewReportStore("")
+	assert.NoError(t, err)
+	assert.NoError(t, disabled.Save(&RunResult{ID: ids[0]}))
+	ids, err = disabled.List()
+	assert.NoError(t, err)
+	assert.Empty(t, ids)
+}
+
+func TestImageReportComplete(t *testing.T) {
+	syncMap := map[string]string{"prod-alix:v0.0.1": "repo missing"}
+
+	report := &ImageReport{
+		Source:      "registry.cn-shanghai.aliyuncs.com/one/alix:v0.0.1",
+		Destination: "registry.cn-hangzhou.aliyuncs.com/two/prod-alix:v0.0.1",
+	}
+	report.complete("one", syncMap)
+	assert.Equal(t, "prod-alix", report.Repo)
+	assert.Equal(t, "v0.0.1", report.Tag)
+	assert.Equal(t, "repo missing", report.Reason)
+
+	// 生成任务失败时 destination 没有tag
+	report = &ImageReport{
+		Source:      "registry.cn-shanghai.aliyuncs.com/one/alix:v0.0.1",
+		Destination: "registry.cn-hangzhou.aliyuncs.com/two/prod-alix",
+		Error:       "unauthorized",
+	}
+	report.complete("one", syncMap)
+	assert.Equal(t, "v0.0.1", report.Tag)
+	assert.Equal(t, "repo missing", report.Reason)
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"aliyun-images-syncer/util/waitutil"
+
+	"github.com/alibabacloud-go/tea/tea"
+)
+
+// DefaultRetryBackoff openapi 调用遇到临时错误时的重试策略，最多调用4次
+var DefaultRetryBackoff = waitutil.Backoff{
+	Duration: 500 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.5,
+	Steps:    4,
+	Cap:      8 * time.Second,
+}
+
+// RetryTransient 遇到临时错误(超时、5xx)时按 backoff 重试，永久错误直接返回，
+// 重试次数用完后返回最后一次的错误
+func RetryTransient(backoff waitutil.Backoff, call func() error) error {
+	var lastErr error
+	err := waitutil.ExponentialBackoff(backoff, func() (bool, error) {
+		lastErr = call()
+		if lastErr == nil {
+			return true, nil
+		}
+		if IsTransientError(lastErr) {
+			return false, nil
+		}
+		return false, lastErr
+	})
+	if errors.Is(err, waitutil.ErrWaitTimeout) {
+		return lastErr
+	}
+	return err
+}
+
+// IsTransientError 是否是可以重试的临时错误，限流错误由 ApiLimiter 退避重试，这里不再重复处理
+func IsTransientError(err error) bool {
+	if IsThrottlingError(err) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	var sdkError *tea.SDKError
+	if errors.As(err, &sdkError) {
+		if tea.IntValue(sdkError.StatusCode) >= 500 {
+			return true
+		}
+		code := tea.StringValue(sdkError.Code)
+		if code == "ServiceUnavailable" || code == "InternalError" || strings.HasPrefix(code, "SystemBusy") {
+			return true
+		}
+		err = errors.New(tea.StringValue(sdkError.Message))
+	}
+
+	// tea 会把网络错误转换成字符串，只能通过错误信息判断
+	msg := strings.ToLower(err.Error())
+	for _, keyword := range []string{"timeout", "timed out", "connection reset", "connection refused", "eof", "temporary failure"} {
+		if strings.Contains(msg, keyword) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"aliyun-images-syncer/util/waitutil"
+
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsTransientError(t *testing.T) {
+	assert.True(t, IsTransientError(&tea.SDKError{StatusCode: tea.Int(503), Code: tea.String("ServiceUnavailable")}))
+	assert.True(t, IsTransientError(&tea.SDKError{Code: tea.String("SystemBusy")}))
+	assert.True(t, IsTransientError(errors.New("Post \"https://cr.cn-hangzhou.aliyuncs.com\": read: connection reset by peer")))
+	assert.True(t, IsTransientError(errors.New("net/http: request canceled (Client.Timeout exceeded)")))
+	// 限流由 ApiLimiter 处理
+	assert.False(t, IsTransientError(&tea.SDKError{StatusCode: tea.Int(429), Code: tea.String("Throttling.User")}))
+	assert.False(t, IsTransientError(&tea.SDKError{StatusCode: tea.Int(404), Code: tea.String("REPO_NOT_EXIST"), Message: tea.String("repo not exist")}))
+}
+
+func TestRetryTransient(t *testing.T) {
+	backoff := waitutil.Backoff{Duration: time.Millisecond, Factor: 2, Steps: 3}
+	transient := &tea.SDKError{StatusCode: tea.Int(500), Code: tea.String("InternalError")}
+
+	// 临时错误重试后成功
+	calls := 0
+	err := RetryTransient(backoff, func() error {
+		calls++
+		if calls < 2 {
+			return transient
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	// 永久错误不重试
+	calls = 0
+	permanent := &tea.SDKError{StatusCode: tea.Int(400), Code: tea.String("InvalidParameter")}
+	err = RetryTransient(backoff, func() error {
+		calls++
+		return permanent
+	})
+	assert.Equal(t, permanent, err)
+	assert.Equal(t, 1, calls)
+
+	// 重试次数用完返回最后一次的错误
+	calls = 0
+	err = RetryTransient(backoff, func() error {
+		calls++
+		return transient
+	})
+	assert.Equal(t, transient, err)
+	assert.Equal(t, 3, calls)
+}
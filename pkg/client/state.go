@@ -0,0 +1,158 @@
+package client
+
+import (
+	"strings"
+	"time"
+
+	"aliyun-images-syncer/pkg/state"
+	"aliyun-images-syncer/pkg/tools"
+)
+
+// pair 按名称查找主从
+func (c *Client) pair(name string) *SyncPair {
+	for _, pair := range c.pairs {
+		if pair.Name == name {
+			return pair
+		}
+	}
+	return nil
+}
+
+// skipVerified 从 syncMap 中删除上次同步成功后主从digest都没有变化的镜像，返回删除的数量
+func (c *Client) skipVerified(api *AlibabacloudApi, syncMap map[string]string, tagMapsMaster, tagMapsSlave tools.RepoTagsMap) int {
+	if c.State == nil {
+		return 0
+	}
+	destination := *api.Slave.Network + "/" + api.Config.DestNamespace(*api.RepoNamespaceName) + "/"
+	var verified int
+	for image, reason := range syncMap {
+		if reason != tools.DiffDigestDrift {
+			continue
+		}
+		st, err := c.State.Get(destination + image)
+		if err != nil {
+			c.Logger.Errorf("Get state of %s error: %v", destination+image, err)
+			continue
+		}
+		repo, tag, _ := strings.Cut(image, ":")
+		if st.Verified(tagMapsMaster.Digest(repo, tag), tagMapsSlave.Digest(repo, tag)) {
+			delete(syncMap, image)
+			verified++
+		}
+	}
+	return verified
+}
+
+// markPending 同步开始前把镜像标记为同步中，服务中途重启后可以恢复
+func (c *Client) markPending(summary *PairSummary) {
+	if c.State == nil || c.DryRun {
+		return
+	}
+	now := time.Now().UTC()
+	for source, destination := range c.config.GetImageList() {
+		sourceURL, err := tools.NewRepoURL(source)
+		// 没有tag时同步repo所有的tag，同步前不知道有哪些tag
+		if err != nil || sourceURL.GetTag() == "" {
+			continue
+		}
+		st := c.imageState(destination + ":" + sourceURL.GetTag())
+		if st == nil {
+			continue
+		}
+		st.Source = source
+		st.Pair = summary.Pair
+		st.Namespace = summary.Namespace
+		st.Repo = sourceURL.GetRepo()
+		st.Tag = sourceURL.GetTag()
+		st.Attempts++
+		st.Pending = true
+		st.LastAttempt = now
+		st.RunID = c.runID
+		if err := c.State.Put(st); err != nil {
+			c.Logger.Errorf("Save state of %s error: %v", st.Destination, err)
+		}
+	}
+}
+
+// saveStates 保存每个镜像的同步结果
+func (c *Client) saveStates(summary *PairSummary) {
+	if c.State == nil || c.DryRun {
+		return
+	}
+	now := time.Now().UTC()
+	for _, report := range summary.Images {
+		destURL, err := tools.NewRepoURL(report.Destination)
+		if err != nil || report.Tag == "" {
+			continue
+		}
+		// 生成任务失败时 destination 没有tag
+		st := c.imageState(destURL.GetURLWithoutTag() + ":" + report.Tag)
+		if st == nil {
+			continue
+		}
+		if sourceURL, err := tools.NewRepoURL(report.Source); err == nil {
+			st.Repo = sourceURL.GetRepo()
+		}
+		if !st.Pending {
+			// 同步整个repo时同步前没有标记
+			st.Attempts++
+		}
+		st.Source = report.Source
+		st.Pair = summary.Pair
+		st.Namespace = summary.Namespace
+		st.Tag = report.Tag
+		st.Pending = false
+		st.LastError = report.Error
+		st.LastAttempt = now
+		st.RunID = c.runID
+		if report.Error == "" {
+			st.Attempts = 0
+			st.SourceDigest = report.SourceDigest
+			st.DestinationDigest = report.DestinationDigest
+			st.LastSynced = now
+		}
+		if err := c.State.Put(st); err != nil {
+			c.Logger.Errorf("Save state of %s error: %v", st.Destination, err)
+		}
+	}
+}
+
+// imageState 读取镜像的状态，不存在时返回一个新的状态，读取失败时返回nil
+func (c *Client) imageState(destination string) *state.ImageState {
+	st, err := c.State.Get(destination)
+	if err != nil {
+		c.Logger.Errorf("Get state of %s error: %v", destination, err)
+		return nil
+	}
+	if st == nil {
+		st = &state.ImageState{Destination: destination}
+	}
+	return st
+}
+
+// resumeTargets 上次中断时正在同步的镜像，主从或者namespace已经不在配置中的忽略
+func (c *Client) resumeTargets() []*SyncTarget {
+	if c.State == nil {
+		return nil
+	}
+	pending, err := c.State.Pending()
+	if err != nil {
+		c.Logger.Errorf("Get pending states error: %v", err)
+		return nil
+	}
+	var targets []*SyncTarget
+	seen := make(map[string]bool)
+	for _, st := range pending {
+		target := &SyncTarget{Pair: st.Pair, Namespace: st.Namespace, Repo: st.Repo, Tag: st.Tag}
+		key := target.Pair + "/" + target.String()
+		if seen[key] || c.CheckTarget(target) != nil {
+			continue
+		}
+		seen[key] = true
+		targets = append(targets, target)
+	}
+	if len(targets) > 0 {
+		c.Logger.Infof("Resume %d interrupted images", len(targets))
+	}
+	return targets
+}
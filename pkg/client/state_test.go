@@ -0,0 +1,60 @@
+package client
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"aliyun-images-syncer/pkg/state"
+	"aliyun-images-syncer/pkg/tools"
+
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSkipVerifiedAndResume(t *testing.T) {
+	store, err := state.NewBoltStore(filepath.Join(t.TempDir(), "state.db"), 0)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	config := &PairConfig{Name: DefaultPairName, Namespaces: []NamespaceRule{{Name: "one", Dest: "prod-one"}}}
+	c := &Client{
+		Logger: logrus.New(),
+		State:  store,
+		pairs:  []*SyncPair{{Name: DefaultPairName, Config: config}},
+	}
+	api := &AlibabacloudApi{Slave: &Alibabacloud{Network: tea.String("prod")}, Config: config, RepoNamespaceName: tea.String("one")}
+
+	now := time.Now()
+	assert.NoError(t, store.Put(&state.ImageState{
+		Destination:       "prod/prod-one/alix:v0.0.1",
+		SourceDigest:      "sha256:list",
+		DestinationDigest: "sha256:amd64",
+		LastAttempt:       now,
+		LastSynced:        now,
+	}))
+	assert.NoError(t, store.Put(&state.ImageState{
+		Destination: "prod/prod-one/alix:v0.0.2",
+		Pair:        DefaultPairName,
+		Namespace:   "one",
+		Repo:        "alix",
+		Tag:         "v0.0.2",
+		Pending:     true,
+		LastAttempt: now,
+	}))
+
+	master := tools.RepoTagsMap{"alix": {Repo: "alix", Tags: []tools.TagInfo{
+		{Tag: "v0.0.1", Digest: "sha256:list"},
+		{Tag: "v0.0.2", Digest: "sha256:v2"},
+	}}}
+	slave := tools.RepoTagsMap{"alix": {Repo: "alix", Tags: []tools.TagInfo{
+		{Tag: "v0.0.1", Digest: "sha256:amd64"},
+		{Tag: "v0.0.2", Digest: "sha256:old"},
+	}}}
+	syncMap := map[string]string{"alix:v0.0.1": tools.DiffDigestDrift, "alix:v0.0.2": tools.DiffDigestDrift}
+	assert.Equal(t, 1, c.skipVerified(api, syncMap, master, slave))
+	assert.Equal(t, map[string]string{"alix:v0.0.2": tools.DiffDigestDrift}, syncMap)
+
+	assert.Equal(t, []*SyncTarget{{Pair: DefaultPairName, Namespace: "one", Repo: "alix", Tag: "v0.0.2"}}, c.resumeTargets())
+}
@@ -0,0 +1,395 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"aliyun-images-syncer/pkg/sync"
+	"aliyun-images-syncer/pkg/tools"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SyncerConfig 同步规则，可以通过 --config 指定的 yaml/json 文件加载，也可以由命令行参数生成
+// 只有一组主从时可以直接使用 master/slave/namespaces，多组主从时使用 pairs，两者二选一
+type SyncerConfig struct {
+	// 主(dev)镜像仓库，用来拉镜像列表
+	Master Registry `json:"master" yaml:"master"`
+	// 从(prod)镜像仓库，用来同步镜像
+	Slave Registry `json:"slave" yaml:"slave"`
+
+	// 需要同步的namespace
+	Namespaces []NamespaceRule `json:"namespaces" yaml:"namespaces"`
+
+	// only images with selected os can be sync
+	OsFilterList []string `json:"os" yaml:"os"`
+	// only images with selected architecture can be sync
+	ArchFilterList []string `json:"arch" yaml:"arch"`
+
+	// If the destination registry and namespace is not provided,
+	// the source image will be synchronized to DefaultDestRegistry
+	// and DefaultDestNamespace with origin repo name and tag.
+	DefaultDestRegistry  string `json:"defaultDestRegistry" yaml:"defaultDestRegistry"`
+	DefaultDestNamespace string `json:"defaultDestNamespace" yaml:"defaultDestNamespace"`
+
+	// 多组主从，每组一个主镜像仓库和多个从镜像仓库
+	Pairs []PairConfig `json:"pairs" yaml:"pairs"`
+
+	// 并发设置，没有填写的设置使用命令行参数
+	Concurrency *ConcurrencyConfig `json:"concurrency" yaml:"concurrency"`
+
+	// 同步镜像的签名、SBOM和attestation，不填时使用命令行参数
+	Referrers *sync.ReferrersOptions `json:"referrers" yaml:"referrers"`
+
+	// 按artifactType选择同步的Helm chart、WASM等非镜像制品，不填时使用命令行参数
+	Artifacts *sync.ArtifactOptions `json:"artifacts" yaml:"artifacts"`
+
+	// 同步后校验从镜像仓库的manifest和blob，也可以使用 --verify
+	Verify bool `json:"verify" yaml:"verify"`
+}
+
+// PairConfig 一组主从同步规则，一个主镜像仓库同步到多个从镜像仓库
+type PairConfig struct {
+	// 名称，用于日志和同步结果
+	Name string `json:"name" yaml:"name"`
+
+	Master Registry   `json:"master" yaml:"master"`
+	Slaves []Registry `json:"slaves" yaml:"slaves"`
+
+	Namespaces []NamespaceRule `json:"namespaces" yaml:"namespaces"`
+
+	OsFilterList   []string `json:"os" yaml:"os"`
+	ArchFilterList []string `json:"arch" yaml:"arch"`
+
+	DefaultDestRegistry  string `json:"defaultDestRegistry" yaml:"defaultDestRegistry"`
+	DefaultDestNamespace string `json:"defaultDestNamespace" yaml:"defaultDestNamespace"`
+
+	// 轮询间隔，单位秒，不填使用 --polling
+	Polling int `json:"polling" yaml:"polling"`
+}
+
+// Registry 一个阿里云镜像仓库实例的访问信息
+type Registry struct {
+	// 名称，用于日志和同步结果，不填使用 network
+	Name string `json:"name" yaml:"name"`
+	// openapi 使用的 AK&SK 和 endpoint，比如 cr.cn-shanghai.aliyuncs.com
+	AccessKeyId     string `json:"accessKeyId" yaml:"accessKeyId"`
+	AccessKeySecret string `json:"accessKeySecret" yaml:"accessKeySecret"`
+	Endpoint        string `json:"endpoint" yaml:"endpoint"`
+	// 实例id
+	InstanceId string `json:"instanceId" yaml:"instanceId"`
+	// 镜像 pull/push 使用的访问地址和账号密码
+	Network  string `json:"network" yaml:"network"`
+	Account  string `json:"account" yaml:"account"`
+	Password string `json:"password" yaml:"password"`
+	// 不校验tls证书，镜像仓库不支持https时使用http
+	Insecure bool `json:"insecure" yaml:"insecure"`
+	// openapi 每个接口的qps限制和令牌桶容量，默认 15/5，付费版本可以调大
+	QPS   float64 `json:"qps" yaml:"qps"`
+	Burst int     `json:"burst" yaml:"burst"`
+}
+
+// NamespaceRule 一个需要同步的namespace
+type NamespaceRule struct {
+	// 主镜像仓库的namespace
+	Name string `json:"name" yaml:"name"`
+	// 从镜像仓库的namespace，为空时和主镜像仓库一致
+	Dest string `json:"dest" yaml:"dest"`
+	// 从镜像仓库的repo名称改写规则，按顺序执行，为空时和主镜像仓库一致
+	Rewrites []tools.RepoRewriteRule `json:"rewrites" yaml:"rewrites"`
+	// namespace下所有repo的tag过滤规则
+	Tags *tools.TagFilter `json:"tags" yaml:"tags"`
+	// 单个repo的tag过滤规则，按顺序匹配第一个，会覆盖 tags
+	Repos []tools.RepoTagFilter `json:"repos" yaml:"repos"`
+	// namespace下镜像的os/arch过滤规则，为空时使用主从配置的 os/arch
+	OsFilterList   []string `json:"os" yaml:"os"`
+	ArchFilterList []string `json:"arch" yaml:"arch"`
+}
+
+// DefaultPairName 使用 master/slave 配置时的主从名称
+const DefaultPairName = "default"
+
+// LoadSyncerConfig 从yaml或者json文件加载同步规则并校验，未知字段会直接报错，避免拼写错误的配置被静默忽略
+func LoadSyncerConfig(path string) (*SyncerConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file %s error: %v", path, err)
+	}
+
+	var config SyncerConfig
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&config)
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&config)
+	default:
+		return nil, fmt.Errorf("unsupported config file %s, only .yaml/.yml/.json are supported", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse config file %s error: %v", path, err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s:\n%v", path, err)
+	}
+	return &config, nil
+}
+
+// Validate 检查同步规则是否完整，一次性返回所有的错误
+func (c *SyncerConfig) Validate() error {
+	if len(c.Pairs) == 0 {
+		pairs := c.SyncPairs()
+		return errors.Join(pairs[0].validate("", "slave")...)
+	}
+
+	var errs []error
+	if c.Master != (Registry{}) || c.Slave != (Registry{}) || len(c.Namespaces) != 0 {
+		errs = append(errs, errors.New("pairs: master/slave/namespaces should not be set when pairs is used"))
+	}
+	seen := make(map[string]bool)
+	for i := range c.Pairs {
+		prefix := fmt.Sprintf("pairs[%d].", i)
+		pair := &c.Pairs[i]
+		if pair.Name == "" {
+			errs = append(errs, fmt.Errorf("%sname is required", prefix))
+		} else if seen[pair.Name] {
+			errs = append(errs, fmt.Errorf("%sname %q is duplicated", prefix, pair.Name))
+		}
+		seen[pair.Name] = true
+		errs = append(errs, pair.validate(prefix, "")...)
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateFlags 检查命令行参数生成的同步规则，和之前的版本一样不要求镜像仓库的账号等字段(比如匿名拉取的主镜像仓库)，
+// 只检查新增的参数，配置文件使用更严格的 Validate
+func (c *SyncerConfig) ValidateFlags() error {
+	var errs []error
+	if c.Master.QPS < 0 || c.Slave.QPS < 0 {
+		errs = append(errs, errors.New("qps should not be negative"))
+	}
+	for _, ns := range c.Namespaces {
+		if strings.Contains(ns.Dest, "/") {
+			errs = append(errs, fmt.Errorf("namespaceMapping %s=%s: namespace should not contain '/'", ns.Name, ns.Dest))
+		}
+	}
+	errs = append(errs, validatePlatformFilter("os", c.OsFilterList)...)
+	errs = append(errs, validatePlatformFilter("arch", c.ArchFilterList)...)
+	return errors.Join(errs...)
+}
+
+// SyncPairs 返回所有的主从配置，使用 master/slave 配置时返回名称为 default 的一组主从
+func (c *SyncerConfig) SyncPairs() []*PairConfig {
+	if len(c.Pairs) == 0 {
+		return []*PairConfig{{
+			Name:                 DefaultPairName,
+			Master:               c.Master,
+			Slaves:               []Registry{c.Slave},
+			Namespaces:           c.Namespaces,
+			OsFilterList:         c.OsFilterList,
+			ArchFilterList:       c.ArchFilterList,
+			DefaultDestRegistry:  c.DefaultDestRegistry,
+			DefaultDestNamespace: c.DefaultDestNamespace,
+		}}
+	}
+
+	pairs := make([]*PairConfig, 0, len(c.Pairs))
+	for i := range c.Pairs {
+		pairs = append(pairs, &c.Pairs[i])
+	}
+	return pairs
+}
+
+// validate 检查一组主从配置，slaveField 不为空时表示只有一个从镜像仓库，错误信息中使用 slaveField 代替 slaves[i]
+func (p *PairConfig) validate(prefix, slaveField string) []error {
+	var errs []error
+	errs = append(errs, p.Master.validate(prefix+"master")...)
+
+	if len(p.Slaves) == 0 {
+		errs = append(errs, fmt.Errorf("%sslaves: at least one slave is required", prefix))
+	}
+	slaveNames := make(map[string]bool)
+	for j := range p.Slaves {
+		field := fmt.Sprintf("%sslaves[%d]", prefix, j)
+		if slaveField != "" {
+			field = prefix + slaveField
+		}
+		errs = append(errs, p.Slaves[j].validate(field)...)
+		if name := p.Slaves[j].DisplayName(); name != "" {
+			if slaveNames[name] {
+				errs = append(errs, fmt.Errorf("%s.name %q is duplicated", field, name))
+			}
+			slaveNames[name] = true
+		}
+	}
+
+	if len(p.Namespaces) == 0 {
+		errs = append(errs, fmt.Errorf("%snamespaces: at least one namespace is required", prefix))
+	}
+	seen := make(map[string]bool)
+	for i, ns := range p.Namespaces {
+		if ns.Name == "" {
+			errs = append(errs, fmt.Errorf("%snamespaces[%d].name is required", prefix, i))
+			continue
+		}
+		if seen[ns.Name] {
+			errs = append(errs, fmt.Errorf("%snamespaces[%d].name %q is duplicated", prefix, i, ns.Name))
+		}
+		seen[ns.Name] = true
+		if strings.Contains(ns.Name, "/") || strings.Contains(ns.Dest, "/") {
+			errs = append(errs, fmt.Errorf("%snamespaces[%d]: namespace should not contain '/'", prefix, i))
+		}
+		if _, err := tools.NewRepoRewriter(ns.Rewrites); err != nil {
+			errs = append(errs, fmt.Errorf("%snamespaces[%d].%v", prefix, i, err))
+		}
+		if _, err := tools.NewRepoTagSelector(ns.Tags, ns.Repos); err != nil {
+			errs = append(errs, fmt.Errorf("%snamespaces[%d].%v", prefix, i, err))
+		}
+		errs = append(errs, validatePlatformFilter(fmt.Sprintf("%snamespaces[%d].os", prefix, i), ns.OsFilterList)...)
+		errs = append(errs, validatePlatformFilter(fmt.Sprintf("%snamespaces[%d].arch", prefix, i), ns.ArchFilterList)...)
+	}
+
+	errs = append(errs, validatePlatformFilter(prefix+"os", p.OsFilterList)...)
+	errs = append(errs, validatePlatformFilter(prefix+"arch", p.ArchFilterList)...)
+
+	if (p.DefaultDestRegistry == "") != (p.DefaultDestNamespace == "") {
+		errs = append(errs, fmt.Errorf("%sdefaultDestRegistry and defaultDestNamespace should be set together", prefix))
+	}
+	if p.Polling < 0 {
+		errs = append(errs, fmt.Errorf("%spolling should not be negative", prefix))
+	}
+	return errs
+}
+
+// NamespaceNames 返回主镜像仓库需要同步的namespace列表
+func (p *PairConfig) NamespaceNames() []string {
+	names := make([]string, 0, len(p.Namespaces))
+	for _, ns := range p.Namespaces {
+		names = append(names, ns.Name)
+	}
+	return names
+}
+
+// DestNamespace 返回主镜像仓库namespace对应的从镜像仓库namespace
+func (p *PairConfig) DestNamespace(name string) string {
+	for _, ns := range p.Namespaces {
+		if ns.Name == name && ns.Dest != "" {
+			return ns.Dest
+		}
+	}
+	return name
+}
+
+// RepoRewriter 返回主镜像仓库namespace对应的repo名称改写规则
+func (p *PairConfig) RepoRewriter(name string) (*tools.RepoRewriter, error) {
+	for _, ns := range p.Namespaces {
+		if ns.Name == name {
+			return tools.NewRepoRewriter(ns.Rewrites)
+		}
+	}
+	return tools.NewRepoRewriter(nil)
+}
+
+// TagSelector 返回主镜像仓库namespace对应的tag过滤规则
+func (p *PairConfig) TagSelector(name string) (*tools.RepoTagSelector, error) {
+	for _, ns := range p.Namespaces {
+		if ns.Name == name {
+			return tools.NewRepoTagSelector(ns.Tags, ns.Repos)
+		}
+	}
+	return nil, nil
+}
+
+// PlatformFilter 返回主镜像仓库namespace对应的os和arch过滤规则，namespace没有配置时使用主从配置的规则
+func (p *PairConfig) PlatformFilter(name string) ([]string, []string) {
+	osFilterList, archFilterList := p.OsFilterList, p.ArchFilterList
+	for _, ns := range p.Namespaces {
+		if ns.Name != name {
+			continue
+		}
+		if len(ns.OsFilterList) != 0 {
+			osFilterList = ns.OsFilterList
+		}
+		if len(ns.ArchFilterList) != 0 {
+			archFilterList = ns.ArchFilterList
+		}
+	}
+	return osFilterList, archFilterList
+}
+
+// validatePlatformFilter 检查os/arch过滤规则，格式为 os[:osversion] 或 arch[:variant]
+func validatePlatformFilter(field string, filters []string) []error {
+	var errs []error
+	for i, f := range filters {
+		name, extra, hasExtra := strings.Cut(f, ":")
+		switch {
+		case f == "":
+			errs = append(errs, fmt.Errorf("%s[%d] should not be empty", field, i))
+		case name == "" || (hasExtra && (extra == "" || strings.Contains(extra, ":"))):
+			errs = append(errs, fmt.Errorf("%s[%d] %q should be name or name:version/variant", field, i, f))
+		}
+	}
+	return errs
+}
+
+// DisplayName 返回镜像仓库的名称，不填使用 network
+func (r *Registry) DisplayName() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.Network
+}
+
+func (r *Registry) validate(field string) []error {
+	var errs []error
+	required := []struct {
+		name  string
+		value string
+	}{
+		{"accessKeyId", r.AccessKeyId},
+		{"accessKeySecret", r.AccessKeySecret},
+		{"endpoint", r.Endpoint},
+		{"instanceId", r.InstanceId},
+		{"network", r.Network},
+		{"account", r.Account},
+		{"password", r.Password},
+	}
+	for _, row := range required {
+		if row.value == "" {
+			errs = append(errs, fmt.Errorf("%s.%s is required", field, row.name))
+		}
+	}
+	if r.QPS < 0 || r.Burst < 0 {
+		errs = append(errs, fmt.Errorf("%s.qps and %s.burst should not be negative", field, field))
+	}
+	if strings.Contains(r.Network, "/") {
+		errs = append(errs, fmt.Errorf("%s.network %q should be a registry host without path", field, r.Network))
+	}
+	return errs
+}
+
+// alibabacloud 转换成 api 使用的 Alibabacloud
+func (r *Registry) alibabacloud() *Alibabacloud {
+	return &Alibabacloud{
+		Name:            r.DisplayName(),
+		Account:         &r.Account,
+		Password:        &r.Password,
+		InstanceId:      &r.InstanceId,
+		AccessKeyId:     &r.AccessKeyId,
+		AccessKeySecret: &r.AccessKeySecret,
+		Endpoint:        &r.Endpoint,
+		Network:         &r.Network,
+		Insecure:        r.Insecure,
+		QPS:             r.QPS,
+		Burst:           r.Burst,
+	}
+}
@@ -0,0 +1,191 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const syncerConfigYaml = `
+master:
+  accessKeyId: ak-dev
+  accessKeySecret: sk-dev
+  endpoint: cr.cn-shanghai.aliyuncs.com
+  instanceId: cri-dev
+  network: dev-registry.cn-shanghai.cr.aliyuncs.com
+  account: dev
+  password: dev-password
+slave:
+  accessKeyId: ak-prod
+  accessKeySecret: sk-prod
+  endpoint: cr.cn-shanghai.aliyuncs.com
+  instanceId: cri-prod
+  network: prod-registry.cn-shanghai.cr.aliyuncs.com
+  account: prod
+  password: prod-password
+namespaces:
+  - name: dev-apps
+    dest: prod-apps
+  - name: base
+    tags:
+      exclude: ["*-SNAPSHOT"]
+    repos:
+      - repo: nginx
+        semver: ">=1.2.0 <2"
+    arch:
+      - amd64
+      - arm64:v8
+arch:
+  - amd64
+`
+
+func writeConfig(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSyncerConfig(t *testing.T) {
+	config, err := LoadSyncerConfig(writeConfig(t, "sync.yaml", syncerConfigYaml))
+	if err != nil {
+		t.Fatalf("load config fail: %v", err)
+	}
+
+	assert.Equal(t, "cri-dev", config.Master.InstanceId)
+	assert.Equal(t, "prod-registry.cn-shanghai.cr.aliyuncs.com", config.Slave.Network)
+
+	pairs := config.SyncPairs()
+	assert.Equal(t, 1, len(pairs))
+	assert.Equal(t, DefaultPairName, pairs[0].Name)
+	assert.Equal(t, "cri-prod", pairs[0].Slaves[0].InstanceId)
+	assert.Equal(t, []string{"dev-apps", "base"}, pairs[0].NamespaceNames())
+	assert.Equal(t, "prod-apps", pairs[0].DestNamespace("dev-apps"))
+	assert.Equal(t, "base", pairs[0].DestNamespace("base"))
+	assert.Equal(t, []string{"amd64"}, pairs[0].ArchFilterList)
+	assert.Equal(t, []string{"*-SNAPSHOT"}, pairs[0].Namespaces[1].Tags.Exclude)
+	assert.Equal(t, ">=1.2.0 <2", pairs[0].Namespaces[1].Repos[0].Semver)
+
+	// namespace的os/arch覆盖主从配置
+	osFilterList, archFilterList := pairs[0].PlatformFilter("dev-apps")
+	assert.Nil(t, osFilterList)
+	assert.Equal(t, []string{"amd64"}, archFilterList)
+	_, archFilterList = pairs[0].PlatformFilter("base")
+	assert.Equal(t, []string{"amd64", "arm64:v8"}, archFilterList)
+}
+
+const syncerPairsConfigYaml = `
+pairs:
+  - name: dev-to-prod
+    polling: 60
+    master:
+      accessKeyId: ak-dev
+      accessKeySecret: sk-dev
+      endpoint: cr.cn-shanghai.aliyuncs.com
+      instanceId: cri-dev
+      network: dev-registry.cn-shanghai.cr.aliyuncs.com
+      account: dev
+      password: dev-password
+    slaves:
+      - name: prod-sh
+        accessKeyId: ak-prod
+        accessKeySecret: sk-prod
+        endpoint: cr.cn-shanghai.aliyuncs.com
+        instanceId: cri-prod-sh
+        network: prod-registry.cn-shanghai.cr.aliyuncs.com
+        account: prod
+        password: prod-password
+      - name: prod-bj
+        accessKeyId: ak-prod
+        accessKeySecret: sk-prod
+        endpoint: cr.cn-beijing.aliyuncs.com
+        instanceId: cri-prod-bj
+        network: prod-registry.cn-beijing.cr.aliyuncs.com
+        account: prod
+        password: prod-password
+    namespaces:
+      - name: apps
+`
+
+func TestLoadSyncerConfigPairs(t *testing.T) {
+	config, err := LoadSyncerConfig(writeConfig(t, "sync.yml", syncerPairsConfigYaml))
+	if err != nil {
+		t.Fatalf("load config fail: %v", err)
+	}
+
+	pairs := config.SyncPairs()
+	assert.Equal(t, 1, len(pairs))
+	assert.Equal(t, "dev-to-prod", pairs[0].Name)
+	assert.Equal(t, 60, pairs[0].Polling)
+	assert.Equal(t, 2, len(pairs[0].Slaves))
+	assert.Equal(t, "prod-bj", pairs[0].Slaves[1].DisplayName())
+
+	// pairs 和 master/slave 不能同时使用
+	_, err = LoadSyncerConfig(writeConfig(t, "sync.yml", syncerPairsConfigYaml+"namespaces:\n  - name: apps\n"))
+	if err == nil || !strings.Contains(err.Error(), "should not be set when pairs is used") {
+		t.Errorf("pairs with namespaces should be rejected, now is -> %v", err)
+	}
+}
+
+func TestLoadSyncerConfigUnknownField(t *testing.T) {
+	_, err := LoadSyncerConfig(writeConfig(t, "sync.yaml", syncerConfigYaml+"namespace: typo\n"))
+	if err == nil || !strings.Contains(err.Error(), "namespace") {
+		t.Errorf("unknown field should be rejected, now is -> %v", err)
+	}
+
+	_, err = LoadSyncerConfig(writeConfig(t, "sync.json", `{"master": {}, "namespcaes": []}`))
+	if err == nil || !strings.Contains(err.Error(), "namespcaes") {
+		t.Errorf("unknown field should be rejected, now is -> %v", err)
+	}
+}
+
+func TestSyncerConfigValidate(t *testing.T) {
+	config := &SyncerConfig{
+		Namespaces:     []NamespaceRule{{Name: "one"}, {Name: "one", OsFilterList: []string{"linux:"}}, {}},
+		ArchFilterList: []string{"arm64:v8", ":v7", ""},
+	}
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("empty config should be invalid")
+	}
+
+	for _, msg := range []string{
+		"master.accessKeyId is required",
+		"slave.instanceId is required",
+		`namespaces[1].name "one" is duplicated`,
+		"namespaces[2].name is required",
+		`namespaces[1].os[0] "linux:" should be name or name:version/variant`,
+		`arch[1] ":v7" should be name or name:version/variant`,
+		"arch[2] should not be empty",
+	} {
+		assert.Contains(t, err.Error(), msg)
+	}
+	assert.NotContains(t, err.Error(), "arch[0]")
+}
+
+func TestSyncerConfigValidateFlags(t *testing.T) {
+	// 命令行参数和之前的版本一样，主镜像仓库可以匿名拉取
+	config := &SyncerConfig{
+		Master:     Registry{Endpoint: "cr.cn-shanghai.aliyuncs.com", QPS: DefaultQPS},
+		Slave:      Registry{AccessKeyId: "ak", AccessKeySecret: "sk", Endpoint: "cr.cn-shanghai.aliyuncs.com", QPS: DefaultQPS},
+		Namespaces: []NamespaceRule{{Name: "one"}, {Name: "two", Dest: "prod-two"}},
+	}
+	assert.NoError(t, config.ValidateFlags())
+	assert.Error(t, config.Validate())
+
+	config.Namespaces[1].Dest = "prod/two"
+	config.ArchFilterList = []string{":v7"}
+	config.Slave.QPS = -1
+	err := config.ValidateFlags()
+	for _, msg := range []string{
+		"qps should not be negative",
+		"namespaceMapping two=prod/two: namespace should not contain '/'",
+		`arch[0] ":v7" should be name or name:version/variant`,
+	} {
+		assert.Contains(t, err.Error(), msg)
+	}
+}
@@ -0,0 +1,215 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"aliyun-images-syncer/pkg/tools"
+
+	cr20181201 "github.com/alibabacloud-go/cr-20181201/v2/client"
+	"github.com/alibabacloud-go/tea/tea"
+)
+
+// SyncTarget 定向同步的目标，格式为 namespace、namespace/repo 或者 namespace/repo:tag
+// 指定了repo时不做对比直接同步，Filtered 为false时也不受tag过滤规则限制
+type SyncTarget struct {
+	// 主从名称，为空时同步所有包含这个namespace的主从
+	Pair      string `json:"pair,omitempty"`
+	Namespace string `json:"namespace"`
+	Repo      string `json:"repo,omitempty"`
+	Tag       string `json:"tag,omitempty"`
+	// 按namespace的tag过滤规则过滤，webhook推送的tag和轮询一样过滤
+	Filtered bool `json:"filtered,omitempty"`
+}
+
+// ParseSyncTarget 解析 namespace[/repo[:tag]]，namespace 为主镜像仓库的namespace，repo 为主镜像仓库的repo名称
+func ParseSyncTarget(pair, target string) (*SyncTarget, error) {
+	t := &SyncTarget{Pair: pair}
+	t.Namespace, t.Repo, _ = strings.Cut(target, "/")
+	if t.Repo != "" {
+		t.Repo, t.Tag, _ = strings.Cut(t.Repo, ":")
+		if t.Tag == "" && strings.HasSuffix(target, ":") {
+			return nil, fmt.Errorf("invalid sync target %q: empty tag", target)
+		}
+	}
+	if t.Namespace == "" || strings.Contains(t.Namespace, ":") || strings.Contains(t.Repo, "/") || strings.HasSuffix(target, "/") {
+		return nil, fmt.Errorf("invalid sync target %q, should be namespace, namespace/repo or namespace/repo:tag", target)
+	}
+	return t, nil
+}
+
+// String 返回 namespace[/repo[:tag]]
+func (t *SyncTarget) String() string {
+	s := t.Namespace
+	if t.Repo != "" {
+		s += "/" + t.Repo
+	}
+	if t.Tag != "" {
+		s += ":" + t.Tag
+	}
+	return s
+}
+
+// match 主从是否需要同步这个目标
+func (t *SyncTarget) match(pair *SyncPair) bool {
+	return (t.Pair == "" || t.Pair == pair.Name) && len(t.namespaces(pair)) > 0
+}
+
+// namespaces 主从需要同步的namespace，t 为空时返回所有的namespace
+func (t *SyncTarget) namespaces(pair *SyncPair) []string {
+	names := pair.Config.NamespaceNames()
+	if t == nil {
+		return names
+	}
+	for _, ns := range names {
+		if ns == t.Namespace {
+			return []string{ns}
+		}
+	}
+	return nil
+}
+
+// CheckTarget 检查是否有主从可以同步这个目标
+func (c *Client) CheckTarget(target *SyncTarget) error {
+	for _, pair := range c.pairs {
+		if target.match(pair) {
+			return nil
+		}
+	}
+	if target.Pair != "" {
+		return fmt.Errorf("namespace %s is not configured in pair %s", target.Namespace, target.Pair)
+	}
+	return fmt.Errorf("namespace %s is not configured in any pair", target.Namespace)
+}
+
+// CheckTargetTag 按tag名称检查是否有主从的tag过滤规则允许同步这个目标，不拉取tag列表
+// newest 和 withinDays 需要完整的tag列表，同步时再过滤
+func (c *Client) CheckTargetTag(target *SyncTarget) error {
+	if target.Tag == "" {
+		return nil
+	}
+	for _, pair := range c.pairs {
+		if !target.match(pair) {
+			continue
+		}
+		selector, err := pair.Config.TagSelector(target.Namespace)
+		// 规则错误在启动时已经校验过，这里交给同步时报错
+		if err != nil || selector.MatchName(target.Repo, target.Tag) {
+			return nil
+		}
+	}
+	return fmt.Errorf("tag %s:%s is excluded by the tag filters of namespace %s", target.Repo, target.Tag, target.Namespace)
+}
+
+// RunTarget 只同步一个namespace、repo或者tag，不影响轮询
+func (c *Client) RunTarget(target *SyncTarget) *RunResult {
+	return c.runTarget(target, nil)
+}
+
+func (c *Client) runTarget(target *SyncTarget, started func(runID string)) *RunResult {
+	if err := c.CheckTarget(target); err != nil {
+		return &RunResult{Msg: err.Error()}
+	}
+	key := "target:" + target.Pair + "/" + target.String()
+	return c.coordinator.Do(key, started, func(id string) *RunResult {
+		return c.run(id, time.Now(), target.match, target)
+	})
+}
+
+// SyncTarget 把一个repo或者tag同步到一组主从的每个从镜像仓库，不拉取镜像列表直接生成同步任务
+func (c *Client) SyncTarget(pair *SyncPair, ns string, target *SyncTarget) []*PairSummary {
+	fmt.Printf("Start syncing %s, pair is %s\n", target, pair.Name)
+
+	for _, api := range pair.Apis {
+		api.RepoNamespaceName = &ns
+	}
+	tags, tagsErr := c.targetTags(pair, ns, target)
+
+	var summaries []*PairSummary
+	for _, api := range pair.Apis {
+		c.startProgress(pair.Name + "/" + api.Slave.Name + "/" + ns)
+		summary := &PairSummary{
+			Pair:        pair.Name,
+			Destination: api.Slave.Name,
+			Namespace:   ns,
+		}
+		c.Prepare()
+		if tagsErr != nil {
+			summary.Error = tagsErr.Error()
+		} else if rewriter, err := api.Config.RepoRewriter(ns); err != nil {
+			summary.Error = err.Error()
+		} else if len(tags) == 0 {
+			fmt.Printf("No tag of %s is selected by the tag filters, pair is %s\n", target, pair.Name)
+		} else {
+			// syncMap 的key为从镜像仓库的repo名称，没有tag时同步repo所有的tag
+			repo := rewriter.Rewrite(target.Repo)
+			syncMap := make(map[string]string, len(tags))
+			for _, tag := range tags {
+				image := repo
+				if tag != "" {
+					image += ":" + tag
+				}
+				syncMap[image] = tools.DiffTargeted
+			}
+			c.syncImages(api, summary, syncMap, map[string]string{repo: target.Repo})
+		}
+		c.finishProgress(summary)
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// targetTags 返回目标需要同步的tag，空字符串表示repo所有的tag
+// Filtered 的目标从主镜像仓库拉取repo的tag列表并按namespace的tag过滤规则过滤，和轮询的结果一致
+func (c *Client) targetTags(pair *SyncPair, ns string, target *SyncTarget) ([]string, error) {
+	if !target.Filtered {
+		return []string{target.Tag}, nil
+	}
+	selector, err := pair.Config.TagSelector(ns)
+	if err != nil {
+		return nil, err
+	}
+	repoTags, err := c.listTargetRepoTags(pair.Apis[0], target.Repo, selector)
+	if err != nil {
+		return nil, err
+	}
+	return selectTargetTags(repoTags, target.Tag), nil
+}
+
+// listTargetRepoTags 只拉取主镜像仓库一个repo满足过滤规则的tag
+func (c *Client) listTargetRepoTags(api *AlibabacloudApi, repo string, selector *tools.RepoTagSelector) (*tools.RepoTags, error) {
+	repositories, err := api.ListRepository(Master)
+	if err != nil {
+		return nil, err
+	}
+	for _, repository := range repositories {
+		if tea.StringValue(repository.RepoName) != repo {
+			continue
+		}
+		tagMaps, err := api.ListRepoTagWithOptionsByRoutine(Master, []*cr20181201.ListRepositoryResponseBodyRepositories{repository}, selector)
+		if err != nil {
+			return nil, err
+		}
+		repoTags := tagMaps[repo]
+		if repoTags == nil {
+			return nil, fmt.Errorf("list tags of %s/%s fail", *api.RepoNamespaceName, repo)
+		}
+		if repoTags.Failed() {
+			return nil, repoTags.Err
+		}
+		return repoTags, nil
+	}
+	return nil, fmt.Errorf("repo %s/%s is not found in master", *api.RepoNamespaceName, repo)
+}
+
+// selectTargetTags 返回过滤后的tag中需要同步的tag，tag 为空时返回repo所有过滤后的tag
+func selectTargetTags(repoTags *tools.RepoTags, tag string) []string {
+	var tags []string
+	for _, info := range repoTags.Tags {
+		if tag == "" || info.Tag == tag {
+			tags = append(tags, info.Tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,36 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSyncTarget(t *testing.T) {
+	for input, want := range map[string]*SyncTarget{
+		"one":              {Namespace: "one"},
+		"one/alix":         {Namespace: "one", Repo: "alix"},
+		"one/alix:v0.0.1":  {Namespace: "one", Repo: "alix", Tag: "v0.0.1"},
+		"one/alix:v1:beta": {Namespace: "one", Repo: "alix", Tag: "v1:beta"},
+	} {
+		target, err := ParseSyncTarget("", input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, want, target, input)
+		assert.Equal(t, input, target.String())
+	}
+
+	for _, input := range []string{"", "/alix", "one/", "one/alix:", "one/a/b", "one:v1"} {
+		_, err := ParseSyncTarget("", input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestSyncTargetNamespaces(t *testing.T) {
+	pair := &SyncPair{Name: "default", Config: &PairConfig{Namespaces: []NamespaceRule{{Name: "one"}, {Name: "two"}}}}
+
+	var all *SyncTarget
+	assert.Equal(t, []string{"one", "two"}, all.namespaces(pair))
+	assert.True(t, (&SyncTarget{Namespace: "two"}).match(pair))
+	assert.False(t, (&SyncTarget{Namespace: "three"}).match(pair))
+	assert.False(t, (&SyncTarget{Pair: "other", Namespace: "two"}).match(pair))
+}
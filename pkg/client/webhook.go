@@ -0,0 +1,59 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// AcrPushEvent 阿里云容器镜像服务推送镜像时触发的webhook
+// https://help.aliyun.com/zh/acr/user-guide/manage-event-notifications
+type AcrPushEvent struct {
+	PushData struct {
+		Digest   string `json:"digest"`
+		PushedAt string `json:"pushed_at"`
+		Tag      string `json:"tag"`
+	} `json:"push_data"`
+	Repository struct {
+		Name         string `json:"name"`
+		Namespace    string `json:"namespace"`
+		Region       string `json:"region"`
+		RepoFullName string `json:"repo_full_name"`
+	} `json:"repository"`
+}
+
+// ParseAcrPushEvent 解析并校验webhook的内容
+func ParseAcrPushEvent(data []byte) (*AcrPushEvent, error) {
+	event := &AcrPushEvent{}
+	if err := json.Unmarshal(data, event); err != nil {
+		return nil, fmt.Errorf("invalid acr push event: %v", err)
+	}
+	var errs []error
+	if event.Repository.Namespace == "" {
+		errs = append(errs, errors.New("repository.namespace should not be empty"))
+	}
+	if event.Repository.Name == "" {
+		errs = append(errs, errors.New("repository.name should not be empty"))
+	}
+	if event.PushData.Tag == "" {
+		errs = append(errs, errors.New("push_data.tag should not be empty"))
+	}
+	if full := event.Repository.Namespace + "/" + event.Repository.Name; event.Repository.RepoFullName != "" && event.Repository.RepoFullName != full {
+		errs = append(errs, fmt.Errorf("repository.repo_full_name %s does not match %s", event.Repository.RepoFullName, full))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("invalid acr push event: %v", err)
+	}
+	return event, nil
+}
+
+// Target 推送的 namespace/repo:tag，pair 为空时同步所有包含这个namespace的主从，和轮询一样按tag过滤规则过滤
+func (e *AcrPushEvent) Target(pair string) *SyncTarget {
+	return &SyncTarget{
+		Pair:      pair,
+		Namespace: e.Repository.Namespace,
+		Repo:      e.Repository.Name,
+		Tag:       e.PushData.Tag,
+		Filtered:  true,
+	}
+}
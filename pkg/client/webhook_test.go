@@ -0,0 +1,61 @@
+package client
+
+import (
+	"testing"
+
+	"aliyun-images-syncer/pkg/tools"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAcrPushEvent(t *testing.T) {
+	event, err := ParseAcrPushEvent([]byte(`{
+		"push_data": {"digest": "sha256:457f4aa83fc9a6663ab9d1b0a6e2dce25a12a943ed5bf2c1747c58d48bbb4917", "pushed_at": "2023-11-29 12:25:46", "tag": "v0.0.1"},
+		"repository": {"date_created": "2023-11-29 12:00:00", "name": "alix", "namespace": "one", "region": "cn-shanghai", "repo_full_name": "one/alix", "repo_type": "PRIVATE"}
+	}`))
+	assert.NoError(t, err)
+	assert.Equal(t, &SyncTarget{Pair: "default", Namespace: "one", Repo: "alix", Tag: "v0.0.1", Filtered: true}, event.Target("default"))
+
+	for _, data := range []string{
+		`not json`,
+		`{"push_data": {"tag": "v0.0.1"}, "repository": {"name": "alix"}}`,
+		`{"push_data": {}, "repository": {"name": "alix", "namespace": "one"}}`,
+		`{"push_data": {"tag": "v0.0.1"}, "repository": {"name": "alix", "namespace": "one", "repo_full_name": "two/alix"}}`,
+	} {
+		_, err := ParseAcrPushEvent([]byte(data))
+		assert.Error(t, err, data)
+	}
+}
+
+func TestWebhookTargetTagFilter(t *testing.T) {
+	c := &Client{pairs: []*SyncPair{{Name: "default", Config: &PairConfig{Namespaces: []NamespaceRule{{
+		Name:  "one",
+		Tags:  &tools.TagFilter{Exclude: []string{"*-SNAPSHOT", "feature-*"}},
+		Repos: []tools.RepoTagFilter{{Repo: "gateway", TagFilter: tools.TagFilter{Semver: ">=1.2.0"}}},
+	}}}}}}
+
+	event, err := ParseAcrPushEvent([]byte(`{"push_data": {"tag": "1.0.0-SNAPSHOT"}, "repository": {"name": "alix", "namespace": "one"}}`))
+	assert.NoError(t, err)
+	target := event.Target("")
+	assert.NoError(t, c.CheckTarget(target))
+	// 轮询不会同步的tag，webhook也不同步
+	assert.EqualError(t, c.CheckTargetTag(target), "tag alix:1.0.0-SNAPSHOT is excluded by the tag filters of namespace one")
+
+	target.Tag = "v1.0.0"
+	assert.NoError(t, c.CheckTargetTag(target))
+	target.Repo, target.Tag = "gateway", "1.1.0"
+	assert.Error(t, c.CheckTargetTag(target))
+
+	// 只有repo的目标同步时按过滤后的tag列表同步
+	target.Tag = ""
+	assert.NoError(t, c.CheckTargetTag(target))
+	repoTags := &tools.RepoTags{Repo: "gateway", Tags: []tools.TagInfo{{Tag: "1.2.0"}, {Tag: "1.3.0"}}}
+	assert.Equal(t, []string{"1.2.0", "1.3.0"}, selectTargetTags(repoTags, ""))
+	assert.Equal(t, []string{"1.3.0"}, selectTargetTags(repoTags, "1.3.0"))
+	assert.Empty(t, selectTargetTags(repoTags, "1.1.0"))
+
+	// 通过 /api/sync 定向同步的目标不过滤
+	tags, err := c.targetTags(c.pairs[0], "one", &SyncTarget{Namespace: "one", Repo: "gateway", Tag: "1.1.0"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.1.0"}, tags)
+}
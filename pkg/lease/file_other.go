@@ -0,0 +1,26 @@
+//go:build !unix
+
+package lease
+
+import (
+	"context"
+	"errors"
+)
+
+// FileLease 文件锁只支持unix系统
+type FileLease struct{}
+
+// NewFileLease creates a FileLease
+func NewFileLease(path, identity string) *FileLease {
+	return &FileLease{}
+}
+
+// TryAcquire 文件锁只支持unix系统
+func (l *FileLease) TryAcquire(ctx context.Context) (bool, error) {
+	return false, errors.New("file lease is only supported on unix")
+}
+
+// Release 文件锁只支持unix系统
+func (l *FileLease) Release(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,70 @@
+//go:build unix
+
+package lease
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// FileLease 基于 flock 的文件锁，适合同一台机器或者共享存储上的多个副本
+// 持有锁的进程退出后操作系统自动释放锁
+type FileLease struct {
+	path     string
+	identity string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileLease creates a FileLease, identity 写入锁文件方便排查是哪个副本持有锁
+func NewFileLease(path, identity string) *FileLease {
+	return &FileLease{path: path, identity: identity}
+}
+
+// TryAcquire 获取锁，已经持有锁时直接返回
+func (l *FileLease) TryAcquire(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		return true, nil
+	}
+
+	file, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return false, fmt.Errorf("open lease file %s error: %v", l.path, err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return false, nil
+		}
+		return false, fmt.Errorf("lock lease file %s error: %v", l.path, err)
+	}
+	if err := file.Truncate(0); err == nil {
+		_, _ = fmt.Fprintf(file, "%s %s\n", l.identity, time.Now().UTC().Format(time.RFC3339))
+	}
+	l.file = file
+	return true, nil
+}
+
+// Release 释放锁
+func (l *FileLease) Release(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	file := l.file
+	l.file = nil
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_UN); err != nil {
+		file.Close()
+		return fmt.Errorf("unlock lease file %s error: %v", l.path, err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,33 @@
+//go:build unix
+
+package lease
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileLease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "images-sync.lock")
+	a, b := NewFileLease(path, "a"), NewFileLease(path, "b")
+	ctx := context.Background()
+
+	ok, err := a.TryAcquire(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = a.TryAcquire(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = b.TryAcquire(ctx)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, a.Release(ctx))
+	ok, err = b.TryAcquire(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, b.Release(ctx))
+}
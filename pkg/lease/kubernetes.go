@@ -0,0 +1,305 @@
+package lease
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound Lease 不存在
+	ErrNotFound = errors.New("lease not found")
+	// ErrConflict Lease 已经被其它副本修改，resourceVersion 不一致
+	ErrConflict = errors.New("lease conflict")
+)
+
+const microTimeLayout = "2006-01-02T15:04:05.000000Z07:00"
+
+// MicroTime kubernetes 中精确到微秒的时间
+type MicroTime struct {
+	time.Time
+}
+
+// MarshalJSON 按 kubernetes 的 MicroTime 格式序列化
+func (t MicroTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.UTC().Format(microTimeLayout))
+}
+
+// UnmarshalJSON 解析 RFC3339 格式的时间
+func (t *MicroTime) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+	return nil
+}
+
+// KubeLease coordination.k8s.io/v1 Lease，只包含选主需要的字段
+type KubeLease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   LeaseMetadata `json:"metadata"`
+	Spec       LeaseSpec     `json:"spec"`
+}
+
+// LeaseMetadata Lease 的 metadata
+type LeaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+// LeaseSpec Lease 的 spec
+type LeaseSpec struct {
+	HolderIdentity       string     `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int32      `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          *MicroTime `json:"acquireTime,omitempty"`
+	RenewTime            *MicroTime `json:"renewTime,omitempty"`
+	LeaseTransitions     int32      `json:"leaseTransitions,omitempty"`
+}
+
+// expired 持有者是否已经超过过期时间没有续约
+func (s *LeaseSpec) expired(now time.Time) bool {
+	if s.HolderIdentity == "" || s.RenewTime == nil {
+		return true
+	}
+	return now.After(s.RenewTime.Add(time.Duration(s.LeaseDurationSeconds) * time.Second))
+}
+
+// LeaseClient 读写 kubernetes Lease 的接口
+// Get 不存在时返回 ErrNotFound，Update 的 resourceVersion 不一致时返回 ErrConflict
+type LeaseClient interface {
+	Get(ctx context.Context, namespace, name string) (*KubeLease, error)
+	Create(ctx context.Context, lease *KubeLease) (*KubeLease, error)
+	Update(ctx context.Context, lease *KubeLease) (*KubeLease, error)
+}
+
+// KubernetesLease 基于 kubernetes Lease 的锁，leader 挂掉后超过 duration 没有续约，其它副本接管
+type KubernetesLease struct {
+	client    LeaseClient
+	namespace string
+	name      string
+	identity  string
+	duration  time.Duration
+	// 方便测试
+	now func() time.Time
+
+	mu sync.Mutex
+	// 最近一次读到的Lease
+	current *KubeLease
+}
+
+// NewKubernetesLease creates a KubernetesLease
+func NewKubernetesLease(client LeaseClient, namespace, name, identity string, duration time.Duration) *KubernetesLease {
+	return &KubernetesLease{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+		identity:  identity,
+		duration:  duration,
+		now:       time.Now,
+	}
+}
+
+// TryAcquire 创建、续约或者接管已经过期的Lease
+func (l *KubernetesLease) TryAcquire(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := &MicroTime{l.now()}
+	current, err := l.client.Get(ctx, l.namespace, l.name)
+	if errors.Is(err, ErrNotFound) {
+		lease := &KubeLease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   LeaseMetadata{Name: l.name, Namespace: l.namespace},
+			Spec: LeaseSpec{
+				HolderIdentity:       l.identity,
+				LeaseDurationSeconds: int32(l.duration / time.Second),
+				AcquireTime:          now,
+				RenewTime:            now,
+			},
+		}
+		created, err := l.client.Create(ctx, lease)
+		if errors.Is(err, ErrConflict) {
+			// 其它副本同时创建了Lease
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		l.current = created
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	lease := *current
+	switch {
+	case lease.Spec.HolderIdentity == l.identity:
+		lease.Spec.RenewTime = now
+	case lease.Spec.expired(now.Time):
+		lease.Spec.HolderIdentity = l.identity
+		lease.Spec.AcquireTime = now
+		lease.Spec.RenewTime = now
+		lease.Spec.LeaseTransitions++
+	default:
+		l.current = current
+		return false, nil
+	}
+	lease.Spec.LeaseDurationSeconds = int32(l.duration / time.Second)
+	updated, err := l.client.Update(ctx, &lease)
+	if errors.Is(err, ErrConflict) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	l.current = updated
+	return true, nil
+}
+
+// Release 清空持有者，其它副本不需要等待过期就可以接管
+func (l *KubernetesLease) Release(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.current == nil || l.current.Spec.HolderIdentity != l.identity {
+		return nil
+	}
+	lease := *l.current
+	lease.Spec.HolderIdentity = ""
+	lease.Spec.RenewTime = nil
+	_, err := l.client.Update(ctx, &lease)
+	l.current = nil
+	if errors.Is(err, ErrConflict) {
+		return nil
+	}
+	return err
+}
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// restLeaseClient 使用 service account 直接调用 kubernetes api，不依赖 client-go
+type restLeaseClient struct {
+	host      string
+	tokenFile string
+	client    *http.Client
+}
+
+// NewInClusterLeaseClient 在pod中使用 service account 访问 kubernetes api，需要 leases 的 get/create/update 权限
+func NewInClusterLeaseClient() (LeaseClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a kubernetes cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("read service account ca error: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("invalid service account ca")
+	}
+	return &restLeaseClient{
+		host:      "https://" + net.JoinHostPort(host, port),
+		tokenFile: serviceAccountDir + "/token",
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+// InClusterNamespace 当前pod所在的namespace
+func InClusterNamespace() string {
+	data, err := os.ReadFile(serviceAccountDir + "/namespace")
+	if err != nil {
+		return "default"
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func (c *restLeaseClient) Get(ctx context.Context, namespace, name string) (*KubeLease, error) {
+	return c.do(ctx, http.MethodGet, leasePath(namespace, name), nil)
+}
+
+func (c *restLeaseClient) Create(ctx context.Context, lease *KubeLease) (*KubeLease, error) {
+	return c.do(ctx, http.MethodPost, leasePath(lease.Metadata.Namespace, ""), lease)
+}
+
+func (c *restLeaseClient) Update(ctx context.Context, lease *KubeLease) (*KubeLease, error) {
+	return c.do(ctx, http.MethodPut, leasePath(lease.Metadata.Namespace, lease.Metadata.Name), lease)
+}
+
+func leasePath(namespace, name string) string {
+	path := "/apis/coordination.k8s.io/v1/namespaces/" + namespace + "/leases"
+	if name != "" {
+		path += "/" + name
+	}
+	return path
+}
+
+func (c *restLeaseClient) do(ctx context.Context, method, path string, lease *KubeLease) (*KubeLease, error) {
+	var body io.Reader
+	if lease != nil {
+		data, err := json.Marshal(lease)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.host+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	// service account token 会定期轮换，每次请求重新读取
+	if c.tokenFile != "" {
+		token, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("read service account token error: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case resp.StatusCode == http.StatusConflict:
+		return nil, ErrConflict
+	case resp.StatusCode >= 300:
+		return nil, fmt.Errorf("%s %s: %s %s", method, path, resp.Status, data)
+	}
+	result := &KubeLease{}
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
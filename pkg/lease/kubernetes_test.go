@@ -0,0 +1,127 @@
+package lease
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeLeaseClient 内存中的 LeaseClient，和 kubernetes 一样按 resourceVersion 做乐观锁
+type fakeLeaseClient struct {
+	mu      sync.Mutex
+	leases  map[string]KubeLease
+	version int
+}
+
+func (c *fakeLeaseClient) Get(ctx context.Context, namespace, name string) (*KubeLease, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	lease, ok := c.leases[namespace+"/"+name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &lease, nil
+}
+
+func (c *fakeLeaseClient) Create(ctx context.Context, lease *KubeLease) (*KubeLease, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := lease.Metadata.Namespace + "/" + lease.Metadata.Name
+	if _, ok := c.leases[key]; ok {
+		return nil, ErrConflict
+	}
+	return c.save(key, lease), nil
+}
+
+func (c *fakeLeaseClient) Update(ctx context.Context, lease *KubeLease) (*KubeLease, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := lease.Metadata.Namespace + "/" + lease.Metadata.Name
+	if c.leases[key].Metadata.ResourceVersion != lease.Metadata.ResourceVersion {
+		return nil, ErrConflict
+	}
+	return c.save(key, lease), nil
+}
+
+func (c *fakeLeaseClient) save(key string, lease *KubeLease) *KubeLease {
+	c.version++
+	saved := *lease
+	saved.Metadata.ResourceVersion = strconv.Itoa(c.version)
+	c.leases[key] = saved
+	return &saved
+}
+
+func TestKubernetesLease(t *testing.T) {
+	client := &fakeLeaseClient{leases: make(map[string]KubeLease)}
+	now := time.Date(2023, 11, 17, 8, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	a := NewKubernetesLease(client, "default", "images-sync", "a", 15*time.Second)
+	a.now = clock
+	b := NewKubernetesLease(client, "default", "images-sync", "b", 15*time.Second)
+	b.now = clock
+	ctx := context.Background()
+
+	ok, err := a.TryAcquire(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = b.TryAcquire(ctx)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// a 续约后 b 仍然拿不到
+	now = now.Add(10 * time.Second)
+	ok, _ = a.TryAcquire(ctx)
+	assert.True(t, ok)
+	now = now.Add(10 * time.Second)
+	ok, _ = b.TryAcquire(ctx)
+	assert.False(t, ok)
+
+	// a 挂掉，过期后 b 接管
+	now = now.Add(10 * time.Second)
+	ok, _ = b.TryAcquire(ctx)
+	assert.True(t, ok)
+	ok, _ = a.TryAcquire(ctx)
+	assert.False(t, ok)
+	lease, _ := client.Get(ctx, "default", "images-sync")
+	assert.Equal(t, "b", lease.Spec.HolderIdentity)
+	assert.Equal(t, int32(1), lease.Spec.LeaseTransitions)
+
+	// b 主动释放后 a 不用等过期
+	assert.NoError(t, b.Release(ctx))
+	ok, _ = a.TryAcquire(ctx)
+	assert.True(t, ok)
+}
+
+func TestRestLeaseClient(t *testing.T) {
+	renewTime := &MicroTime{time.Date(2023, 11, 17, 8, 0, 0, 123456000, time.UTC)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/apis/coordination.k8s.io/v1/namespaces/default/leases/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPut && r.URL.Path == "/apis/coordination.k8s.io/v1/namespaces/default/leases/images-sync":
+			var body map[string]any
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			assert.Equal(t, "2023-11-17T08:00:00.123456Z", body["spec"].(map[string]any)["renewTime"])
+			w.WriteHeader(http.StatusConflict)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := &restLeaseClient{host: server.URL, client: server.Client()}
+	_, err := client.Get(context.Background(), "default", "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = client.Update(context.Background(), &KubeLease{
+		Metadata: LeaseMetadata{Name: "images-sync", Namespace: "default"},
+		Spec:     LeaseSpec{HolderIdentity: "a", RenewTime: renewTime},
+	})
+	assert.ErrorIs(t, err, ErrConflict)
+}
@@ -0,0 +1,121 @@
+package lease
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Lease 分布式锁，多副本部署时只有持有锁的副本执行同步
+// 持有锁的副本挂掉后，锁会被释放或者过期，其它副本接管
+type Lease interface {
+	// TryAcquire 获取或者续约锁，返回当前副本是否持有锁，不会阻塞等待
+	TryAcquire(ctx context.Context) (bool, error)
+	// Release 主动释放锁，没有持有锁时什么都不做
+	Release(ctx context.Context) error
+}
+
+// Elector 定期获取或者续约锁，记录当前副本是否是leader
+type Elector struct {
+	lease       Lease
+	retryPeriod time.Duration
+	logger      *logrus.Logger
+
+	leader atomic.Bool
+	mu     sync.Mutex
+	// 成为leader时创建，失去leader时关闭
+	lost chan struct{}
+}
+
+// NewElector creates an Elector, retryPeriod 需要小于锁的过期时间，否则leader来不及续约
+func NewElector(lease Lease, retryPeriod time.Duration, logger *logrus.Logger) *Elector {
+	if logger == nil {
+		logger = logrus.New()
+	}
+	return &Elector{lease: lease, retryPeriod: retryPeriod, logger: logger}
+}
+
+// Run 每隔 retryPeriod 获取或者续约一次锁，直到 ctx 结束后释放锁
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.retryPeriod)
+	defer ticker.Stop()
+	for {
+		e.tryAcquire(ctx)
+		select {
+		case <-ctx.Done():
+			e.setLeader(false)
+			// ctx 已经结束，使用新的ctx释放锁
+			releaseCtx, cancel := context.WithTimeout(context.Background(), e.retryPeriod)
+			if err := e.lease.Release(releaseCtx); err != nil {
+				e.logger.Errorf("Release lease error: %v", err)
+			}
+			cancel()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Elector) tryAcquire(ctx context.Context) {
+	leader, err := e.lease.TryAcquire(ctx)
+	if err != nil {
+		// 续约失败时无法确定锁是否还在，按没有持有锁处理，避免两个副本同时同步
+		e.logger.Errorf("Acquire lease error: %v", err)
+		leader = false
+	}
+	if e.setLeader(leader) {
+		if leader {
+			e.logger.Info("Became the leader, start syncing")
+		} else {
+			e.logger.Info("Lost the leadership, stop syncing")
+		}
+	}
+}
+
+// setLeader 记录是否是leader，返回是否发生了变化
+func (e *Elector) setLeader(leader bool) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.leader.Swap(leader) == leader {
+		return false
+	}
+	if leader {
+		e.lost = make(chan struct{})
+	} else if e.lost != nil {
+		close(e.lost)
+		e.lost = nil
+	}
+	return true
+}
+
+// LeaderContext 返回失去leader时取消的ctx，同步过程中锁过期或者被其它副本抢走后停止推送，
+// 当前不是leader时返回的ctx已经取消，没有配置锁(nil)时只在 parent 结束时取消
+func (e *Elector) LeaderContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	if e == nil {
+		return ctx, cancel
+	}
+	e.mu.Lock()
+	lost := e.lost
+	e.mu.Unlock()
+	if lost == nil {
+		cancel()
+		return ctx, cancel
+	}
+	go func() {
+		select {
+		case <-lost:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// IsLeader 当前副本是否持有锁，没有配置锁(nil)时总是leader
+func (e *Elector) IsLeader() bool {
+	return e == nil || e.leader.Load()
+}
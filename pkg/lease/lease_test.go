@@ -0,0 +1,87 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeLease struct {
+	acquire  atomic.Bool
+	err      atomic.Bool
+	released atomic.Bool
+}
+
+func (l *fakeLease) TryAcquire(ctx context.Context) (bool, error) {
+	if l.err.Load() {
+		return true, errors.New("timeout")
+	}
+	return l.acquire.Load(), nil
+}
+
+func (l *fakeLease) Release(ctx context.Context) error {
+	l.released.Store(true)
+	return nil
+}
+
+func TestElector(t *testing.T) {
+	var none *Elector
+	assert.True(t, none.IsLeader())
+
+	l := &fakeLease{}
+	e := NewElector(l, time.Millisecond, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+
+	assert.Never(t, e.IsLeader, 20*time.Millisecond, time.Millisecond)
+	l.acquire.Store(true)
+	assert.Eventually(t, e.IsLeader, time.Second, time.Millisecond)
+	// 续约出错时不再认为自己是leader
+	l.err.Store(true)
+	assert.Eventually(t, func() bool { return !e.IsLeader() }, time.Second, time.Millisecond)
+
+	cancel()
+	<-done
+	assert.True(t, l.released.Load())
+}
+
+func TestElectorLeaderContext(t *testing.T) {
+	var none *Elector
+	ctx, cancel := none.LeaderContext(context.Background())
+	assert.NoError(t, ctx.Err())
+	cancel()
+
+	l := &fakeLease{}
+	e := NewElector(l, time.Millisecond, nil)
+	// 不是leader时ctx已经取消
+	ctx, cancel = e.LeaderContext(context.Background())
+	assert.Error(t, ctx.Err())
+	cancel()
+
+	runCtx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(runCtx)
+		close(done)
+	}()
+	l.acquire.Store(true)
+	assert.Eventually(t, e.IsLeader, time.Second, time.Millisecond)
+	ctx, cancel = e.LeaderContext(context.Background())
+	defer cancel()
+	assert.NoError(t, ctx.Err())
+
+	// 同步过程中失去leader，ctx被取消
+	l.acquire.Store(false)
+	assert.Eventually(t, func() bool { return ctx.Err() != nil }, time.Second, time.Millisecond)
+
+	stop()
+	<-done
+}
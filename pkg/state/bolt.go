@@ -0,0 +1,167 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	imagesBucket  = []byte("images")
+	historyBucket = []byte("history")
+)
+
+// historyKeyLayout 固定宽度的UTC时间，key按字节排序就是按时间排序
+const historyKeyLayout = "20060102T150405.000000000Z"
+
+// BoltStore 基于 BoltDB 的 Store，数据保存在一个本地文件中
+type BoltStore struct {
+	db *bolt.DB
+	// 每个镜像保留的历史数量，为0时不清理
+	historyLimit int
+}
+
+// NewBoltStore 打开或者创建 BoltDB 文件，同一个文件只能被一个进程打开，historyLimit 为每个镜像保留的历史数量，为0时不清理
+func NewBoltStore(path string, historyLimit int) (*BoltStore, error) {
+	if historyLimit < 0 {
+		return nil, fmt.Errorf("history limit of state store should not be negative")
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open state store %s error: %v", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{imagesBucket, historyBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init state store %s error: %v", path, err)
+	}
+	return &BoltStore{db: db, historyLimit: historyLimit}, nil
+}
+
+// Get 查询一个镜像的状态
+func (s *BoltStore) Get(destination string) (*ImageState, error) {
+	var state *ImageState
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(imagesBucket).Get([]byte(destination))
+		if data == nil {
+			return nil
+		}
+		state = &ImageState{}
+		return json.Unmarshal(data, state)
+	})
+	return state, err
+}
+
+// Put 保存镜像的状态，同时记录一条历史，超过 historyLimit 的旧历史会被删除
+func (s *BoltStore) Put(state *ImageState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(imagesBucket).Put([]byte(state.Destination), data); err != nil {
+			return err
+		}
+		if err := tx.Bucket(historyBucket).Put(historyKey(state.Destination, state.LastAttempt), data); err != nil {
+			return err
+		}
+		return s.pruneHistory(tx, state.Destination)
+	})
+}
+
+// historyKey 为 destination\x00时间，同一个镜像的历史按时间排序
+func historyKey(destination string, t time.Time) []byte {
+	return []byte(destination + "\x00" + t.UTC().Format(historyKeyLayout))
+}
+
+// pruneHistory 删除一个镜像超过 historyLimit 的最旧的历史
+func (s *BoltStore) pruneHistory(tx *bolt.Tx, destination string) error {
+	if s.historyLimit == 0 {
+		return nil
+	}
+	bucket := tx.Bucket(historyBucket)
+	prefix := []byte(destination + "\x00")
+	var keys [][]byte
+	c := bucket.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, bytes.Clone(k))
+	}
+	// keys 从旧到新，保留最后 historyLimit 个
+	for i := 0; i < len(keys)-s.historyLimit; i++ {
+		if err := bucket.Delete(keys[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List 所有 destination 以 prefix 开头的镜像的状态
+func (s *BoltStore) List(prefix string) ([]*ImageState, error) {
+	return s.list(func(state *ImageState) bool { return strings.HasPrefix(state.Destination, prefix) })
+}
+
+// Pending 开始同步但是没有结果的镜像
+func (s *BoltStore) Pending() ([]*ImageState, error) {
+	return s.list(func(state *ImageState) bool { return state.Pending })
+}
+
+func (s *BoltStore) list(filter func(state *ImageState) bool) ([]*ImageState, error) {
+	var states []*ImageState
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(imagesBucket).ForEach(func(k, v []byte) error {
+			state := &ImageState{}
+			if err := json.Unmarshal(v, state); err != nil {
+				return err
+			}
+			if filter(state) {
+				states = append(states, state)
+			}
+			return nil
+		})
+	})
+	return states, err
+}
+
+// History 一个镜像的同步历史，最新的在前面
+func (s *BoltStore) History(destination string, limit int) ([]*ImageState, error) {
+	var states []*ImageState
+	prefix := []byte(destination + "\x00")
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(historyBucket).Cursor()
+		// 定位到最后一个以 prefix 开头的key，然后倒序遍历
+		k, v := c.Seek(append(bytes.Clone(prefix), 0xff))
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, v = c.Prev() {
+			state := &ImageState{}
+			if err := json.Unmarshal(v, state); err != nil {
+				return err
+			}
+			states = append(states, state)
+			if limit > 0 && len(states) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+	return states, err
+}
+
+// Close 关闭 BoltDB 文件
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,99 @@
+package state
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	store, err := NewBoltStore(path, 0)
+	assert.NoError(t, err)
+
+	startedAt := time.Date(2023, 11, 17, 8, 0, 0, 0, time.UTC)
+	alix := &ImageState{
+		Destination: "prod/one/alix:v0.0.1",
+		Pair:        "default",
+		Namespace:   "one",
+		Repo:        "alix",
+		Tag:         "v0.0.1",
+		Attempts:    1,
+		Pending:     true,
+		LastAttempt: startedAt,
+	}
+	assert.NoError(t, store.Put(alix))
+	assert.NoError(t, store.Put(&ImageState{Destination: "prod/two/bob:v1", LastAttempt: startedAt}))
+	assert.NoError(t, store.Put(&ImageState{Destination: "prod/one/alix:v0.0.10", LastAttempt: startedAt}))
+
+	pending, err := store.Pending()
+	assert.NoError(t, err)
+	assert.Equal(t, []*ImageState{alix}, pending)
+
+	// 重启后状态仍然存在
+	assert.NoError(t, store.Close())
+	store, err = NewBoltStore(path, 0)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	synced := *alix
+	synced.Pending = false
+	synced.Attempts = 0
+	synced.SourceDigest, synced.DestinationDigest = "sha256:a", "sha256:b"
+	synced.LastAttempt = startedAt.Add(time.Minute)
+	synced.LastSynced = synced.LastAttempt
+	assert.NoError(t, store.Put(&synced))
+
+	state, err := store.Get("prod/one/alix:v0.0.1")
+	assert.NoError(t, err)
+	assert.True(t, state.Verified("sha256:a", "sha256:b"))
+	assert.False(t, state.Verified("sha256:c", "sha256:b"))
+	state, err = store.Get("prod/one/missing:v1")
+	assert.NoError(t, err)
+	assert.Nil(t, state)
+
+	states, err := store.List("prod/one/")
+	assert.NoError(t, err)
+	assert.Len(t, states, 2)
+
+	// 历史不包含 alix:v0.0.10
+	history, err := store.History("prod/one/alix:v0.0.1", 0)
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+	assert.False(t, history[0].Pending)
+	assert.True(t, history[1].Pending)
+	history, err = store.History("prod/one/alix:v0.0.1", 1)
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+}
+
+func TestBoltStoreHistory(t *testing.T) {
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "state.db"), 3)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	// 整秒的时间和带小数的时间按时间排序
+	startedAt := time.Date(2023, 11, 17, 8, 0, 0, 0, time.UTC)
+	for _, offset := range []time.Duration{0, 500 * time.Millisecond, time.Second, 1500 * time.Millisecond, 2 * time.Second} {
+		assert.NoError(t, store.Put(&ImageState{Destination: "prod/one/alix:v1", LastAttempt: startedAt.Add(offset)}))
+	}
+	assert.NoError(t, store.Put(&ImageState{Destination: "prod/one/alix:v10", LastAttempt: startedAt}))
+
+	// 只保留最新的3条
+	history, err := store.History("prod/one/alix:v1", 0)
+	assert.NoError(t, err)
+	var attempts []time.Time
+	for _, state := range history {
+		attempts = append(attempts, state.LastAttempt)
+	}
+	assert.Equal(t, []time.Time{startedAt.Add(2 * time.Second), startedAt.Add(1500 * time.Millisecond), startedAt.Add(time.Second)}, attempts)
+
+	history, err = store.History("prod/one/alix:v10", 0)
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+
+	_, err = NewBoltStore(filepath.Join(t.TempDir(), "state.db"), -1)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,55 @@
+package state
+
+import (
+	"time"
+)
+
+// ImageState 一个镜像 repo:tag 同步到一个从镜像仓库的状态
+type ImageState struct {
+	// 从镜像仓库的 registry/namespace/repo:tag，作为主键
+	Destination string `json:"destination"`
+	Source      string `json:"source"`
+
+	// 定位这个镜像的同步规则，中断后恢复同步时使用
+	Pair      string `json:"pair"`
+	Namespace string `json:"namespace"`
+	// 主镜像仓库的repo名称
+	Repo string `json:"repo"`
+	Tag  string `json:"tag"`
+
+	// 最后一次同步成功时主从manifest的digest
+	SourceDigest      string `json:"sourceDigest,omitempty"`
+	DestinationDigest string `json:"destinationDigest,omitempty"`
+
+	// 同步的次数，成功后清零
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"lastError,omitempty"`
+	// 已经开始同步但是还没有结果，服务重启后需要恢复
+	Pending bool `json:"pending"`
+
+	LastAttempt time.Time `json:"lastAttempt"`
+	LastSynced  time.Time `json:"lastSynced,omitempty"`
+	// 最后一次同步所在的那一轮同步报告的id
+	RunID string `json:"runId,omitempty"`
+}
+
+// Verified 上一次同步成功，并且主从的digest和现在一致，不需要再同步
+func (s *ImageState) Verified(sourceDigest, destinationDigest string) bool {
+	return s != nil && !s.Pending && s.LastError == "" && !s.LastSynced.IsZero() &&
+		s.SourceDigest == sourceDigest && s.DestinationDigest == destinationDigest
+}
+
+// Store 持久化每个镜像的同步状态和同步历史
+type Store interface {
+	// Get 查询一个镜像的状态，不存在时返回nil
+	Get(destination string) (*ImageState, error)
+	// Put 保存镜像的状态，同时记录一条历史
+	Put(state *ImageState) error
+	// List 所有 destination 以 prefix 开头的镜像的状态
+	List(prefix string) ([]*ImageState, error)
+	// Pending 开始同步但是没有结果的镜像
+	Pending() ([]*ImageState, error)
+	// History 一个镜像的同步历史，最新的在前面，limit 为0时返回所有的历史
+	History(destination string, limit int) ([]*ImageState, error)
+	Close() error
+}
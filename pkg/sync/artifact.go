@@ -0,0 +1,102 @@
+package sync
+
+import (
+	"encoding/json"
+	"path"
+
+	"github.com/containers/image/v5/manifest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ArtifactOptions selects the OCI artifacts which are not container images, such as Helm charts and WASM modules,
+// the selected artifacts are copied verbatim
+type ArtifactOptions struct {
+	// glob patterns of artifact types which are synced, empty means all
+	Include []string `json:"include" yaml:"include"`
+	// glob patterns of artifact types which are not synced, it takes precedence over Include
+	Exclude []string `json:"exclude" yaml:"exclude"`
+}
+
+// Match checks if an artifact type is selected, all artifacts are selected by nil options
+func (o *ArtifactOptions) Match(artifactType string) bool {
+	if o == nil {
+		return true
+	}
+	if matchAny(o.Exclude, artifactType) {
+		return false
+	}
+	return len(o.Include) == 0 || matchAny(o.Include, artifactType)
+}
+
+// matchAny checks if s matches one of the glob patterns
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, s); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// SetArtifacts selects the artifacts which are synced, nil means all
+func (t *Task) SetArtifacts(options *ArtifactOptions) {
+	t.artifacts = options
+}
+
+// isImageConfig checks if a config media type is the config of a container image
+func isImageConfig(mediaType string) bool {
+	return mediaType == imgspecv1.MediaTypeImageConfig || mediaType == manifest.DockerV2Schema2ConfigMediaType
+}
+
+// manifestArtifactType returns the artifact type of a manifest, empty if it is a container image or a list.
+// OCI image manifests with the artifactType field or a config which is not an image config are artifacts.
+func manifestArtifactType(manifestBytes []byte, mediaType string) (string, error) {
+	if mediaType != imgspecv1.MediaTypeImageManifest && mediaType != artifactManifestMediaType {
+		return "", nil
+	}
+	var m artifactManifest
+	if err := json.Unmarshal(manifestBytes, &m); err != nil {
+		return "", err
+	}
+	if mediaType == artifactManifestMediaType || m.ArtifactType != "" ||
+		(m.Config != nil && m.Config.MediaType != "" && !isImageConfig(m.Config.MediaType)) {
+		return m.artifactType(), nil
+	}
+	return "", nil
+}
+
+// resolveArtifact returns the blobs of an artifact, it returns nil if the artifact type is not selected
+func (t *Task) resolveArtifact(manifestBytes []byte, mediaType, artifactType string) (*resolvedManifest, error) {
+	t.stats.ArtifactType = artifactType
+	if !t.artifacts.Match(artifactType) {
+		t.Infof("Skip synchronization from %s/%s:%s to %s/%s:%s, artifact type %s is not selected",
+			t.source.GetRegistry(), t.source.GetRepository(), t.source.GetTag(),
+			t.destination.GetRegistry(), t.destination.GetRepository(), t.destination.GetTag(), artifactType)
+		return nil, nil
+	}
+
+	var m artifactManifest
+	if err := json.Unmarshal(manifestBytes, &m); err != nil {
+		return nil, t.Errorf("Parse artifact manifest from %s/%s:%s error: %v",
+			t.source.GetRegistry(), t.source.GetRepository(), t.source.GetTag(), err)
+	}
+	t.stats.Manifests = 1
+	return &resolvedManifest{
+		bytes:        manifestBytes,
+		mediaType:    mediaType,
+		artifactType: artifactType,
+		blobInfos:    m.blobInfos(),
+	}, nil
+}
+
+// pushArtifact pushes the manifest of an artifact as it is, containers/image may not accept its media type
+func (t *Task) pushArtifact(resolved *resolvedManifest) error {
+	if err := t.destination.client.putManifest(t.destination.ctx, t.destination.GetTag(), resolved.bytes, resolved.mediaType); err != nil {
+		return t.Errorf("Put artifact manifest(%s) to %s/%s:%s error: %v", resolved.artifactType,
+			t.destination.GetRegistry(), t.destination.GetRepository(), t.destination.GetTag(), err)
+	}
+	t.setDestinationDigest(resolved.bytes)
+	t.Infof("Put artifact manifest(%s) to %s/%s:%s", resolved.artifactType,
+		t.destination.GetRegistry(), t.destination.GetRepository(), t.destination.GetTag())
+	return nil
+}
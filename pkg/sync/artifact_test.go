@@ -0,0 +1,73 @@
+package sync
+
+import (
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+const helmConfigMediaType = "application/vnd.cncf.helm.config.v1+json"
+
+func TestArtifactOptionsMatch(t *testing.T) {
+	var options *ArtifactOptions
+	assert.True(t, options.Match(helmConfigMediaType), "all artifacts are synced by default")
+
+	options = &ArtifactOptions{Include: []string{"application/vnd.cncf.helm.*", "application/vnd.wasm.*"}}
+	assert.True(t, options.Match(helmConfigMediaType))
+	assert.False(t, options.Match("application/vnd.unknown.config.v1+json"))
+
+	options.Exclude = []string{"application/vnd.wasm.*"}
+	assert.False(t, options.Match("application/vnd.wasm.config.v1+json"))
+
+	options = &ArtifactOptions{Exclude: []string{"application/vnd.wasm.*"}}
+	assert.True(t, options.Match(helmConfigMediaType))
+}
+
+func TestManifestArtifactType(t *testing.T) {
+	r := newFakeRegistry(t)
+	chart := artifact(t, r, helmConfigMediaType, []byte("chart"), "application/vnd.cncf.helm.chart.content.v1.tar+gzip", "")
+	artifactType, err := manifestArtifactType(chart, imgspecv1.MediaTypeImageManifest)
+	assert.NoError(t, err)
+	assert.Equal(t, helmConfigMediaType, artifactType)
+
+	// images, including cosign signatures with an image config, are not artifacts
+	artifactType, err = manifestArtifactType([]byte(ociManifestAmd64), imgspecv1.MediaTypeImageManifest)
+	assert.NoError(t, err)
+	assert.Empty(t, artifactType)
+	artifactType, err = manifestArtifactType([]byte(ociIndex()), imgspecv1.MediaTypeImageIndex)
+	assert.NoError(t, err)
+	assert.Empty(t, artifactType)
+
+	artifactType, err = manifestArtifactType([]byte(`{"mediaType": "`+artifactManifestMediaType+`", "artifactType": "application/vnd.example.sbom"}`), artifactManifestMediaType)
+	assert.NoError(t, err)
+	assert.Equal(t, "application/vnd.example.sbom", artifactType)
+}
+
+func TestRunArtifact(t *testing.T) {
+	r := newFakeRegistry(t)
+	chart := artifact(t, r, helmConfigMediaType, []byte("chart"), "application/vnd.cncf.helm.chart.content.v1.tar+gzip", "")
+
+	task := newFakeTask(r)
+	task.source.source.(*fakeImageSource).manifest = chart
+	task.destination.tag = "1.0.0"
+	assert.NoError(t, task.Run())
+
+	// the manifest and the blobs are copied verbatim
+	assert.Equal(t, chart, r.manifests["dst/1.0.0"])
+	assert.Contains(t, r.committed, digest.FromBytes([]byte("chart")))
+	assert.Equal(t, helmConfigMediaType, task.Stats().ArtifactType)
+	assert.Equal(t, digest.FromBytes(chart).String(), task.Stats().DestinationDigest)
+	assert.Equal(t, 2, task.Stats().BlobsTransferred)
+
+	// excluded artifacts are skipped
+	r.manifests = map[string][]byte{}
+	task = newFakeTask(r)
+	task.source.source.(*fakeImageSource).manifest = chart
+	task.destination.tag = "1.0.0"
+	task.SetArtifacts(&ArtifactOptions{Exclude: []string{"application/vnd.cncf.helm.*"}})
+	assert.NoError(t, task.Run())
+	assert.Empty(t, r.manifests)
+	assert.Equal(t, helmConfigMediaType, task.Stats().ArtifactType)
+}
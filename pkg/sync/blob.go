@@ -0,0 +1,214 @@
+package sync
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"golang.org/x/time/rate"
+)
+
+// DefaultChunkSize is the size of every PATCH request of a chunked upload,
+// only one chunk of every blob is kept in memory
+const DefaultChunkSize = 8 << 20
+
+// BlobProgress is reported after every chunk of a blob is uploaded to destination
+type BlobProgress struct {
+	Digest digest.Digest
+	// size in the manifest, -1 if unknown
+	Size int64
+	// bytes accepted by destination, including the bytes uploaded by interrupted runs
+	Offset int64
+	// bytes uploaded since the last event
+	Written int64
+	// the blob is verified and committed
+	Done bool
+}
+
+// uploadSession is an upload of a blob to destination,
+// it is kept after a failed run so the next retry resumes from the accepted bytes
+type uploadSession struct {
+	location string
+	// bytes accepted by destination
+	offset int64
+	// marshaled state of the digest hash of the accepted bytes
+	hashState []byte
+}
+
+// SetBandwidth limits the bytes per second pulled from source of every run, 0 means no limit
+func (t *Task) SetBandwidth(bytesPerSecond int) {
+	t.bandwidth = bytesPerSecond
+}
+
+// SetProgress sets the function called after every chunk of a blob is uploaded
+func (t *Task) SetProgress(progress func(BlobProgress)) {
+	t.progress = progress
+}
+
+func (t *Task) reportProgress(p BlobProgress) {
+	if t.progress != nil {
+		t.progress(p)
+	}
+}
+
+// newLimiter creates the bandwidth limiter shared by all blobs of a run, nil if there is no limit
+func (t *Task) newLimiter() *rate.Limiter {
+	if t.bandwidth <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(t.bandwidth), t.bandwidth)
+}
+
+// pushBlob streams a blob from source to destination chunk by chunk and verifies its digest,
+// it returns the bytes transferred by this run and the size of the blob
+func (t *Task) pushBlob(b types.BlobInfo) (int64, int64, error) {
+	if err := b.Digest.Validate(); err != nil {
+		return 0, 0, t.Errorf("Invalid blob digest %s of %s/%s:%s: %v",
+			b.Digest, t.source.GetRegistry(), t.source.GetRepository(), t.source.GetTag(), err)
+	}
+
+	session, hasher := t.resumeUpload(b.Digest)
+	if session == nil {
+		location, err := t.destination.startUpload()
+		if err != nil {
+			return 0, 0, t.Errorf("Start upload of blob %s to %s/%s:%s failed: %v",
+				b.Digest, t.destination.GetRegistry(), t.destination.GetRepository(), t.destination.GetTag(), err)
+		}
+		session, hasher = &uploadSession{location: location}, b.Digest.Algorithm().Hash()
+	}
+	start := session.offset
+
+	blob, err := t.source.GetABlobFrom(b, session.offset)
+	if err != nil {
+		t.keepUpload(b.Digest, session)
+		return 0, 0, t.Errorf("Get blob %s(%v) from %s/%s:%s failed: %v",
+			b.Digest, b.Size, t.source.GetRegistry(), t.source.GetRepository(), t.source.GetTag(), err)
+	}
+	defer blob.Close()
+
+	var reader io.Reader = blob
+	if t.limiter != nil {
+		reader = &limitedReader{reader: blob, limiter: t.limiter, ctx: t.source.ctx}
+	}
+	chunk := make([]byte, t.chunkSize)
+	for {
+		n, readErr := io.ReadFull(reader, chunk)
+		if n > 0 {
+			location, err := t.destination.uploadChunk(session.location, chunk[:n], session.offset)
+			if err != nil {
+				t.keepUpload(b.Digest, session)
+				return session.offset - start, 0, t.Errorf("Put blob %s(%v) to %s/%s:%s failed at %d bytes: %v",
+					b.Digest, b.Size, t.destination.GetRegistry(), t.destination.GetRepository(), t.destination.GetTag(),
+					session.offset, err)
+			}
+			hasher.Write(chunk[:n])
+			session.location = location
+			session.offset += int64(n)
+			session.hashState = marshalHash(hasher)
+			t.reportProgress(BlobProgress{Digest: b.Digest, Size: b.Size, Offset: session.offset, Written: int64(n)})
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			t.keepUpload(b.Digest, session)
+			return session.offset - start, 0, t.Errorf("Get blob %s(%v) from %s/%s:%s failed at %d bytes: %v",
+				b.Digest, b.Size, t.source.GetRegistry(), t.source.GetRepository(), t.source.GetTag(),
+				session.offset, readErr)
+		}
+	}
+
+	// the streamed bytes must match the manifest before the blob is committed
+	streamed := digest.NewDigest(b.Digest.Algorithm(), hasher)
+	if streamed != b.Digest || (b.Size > 0 && session.offset != b.Size) {
+		t.destination.cancelUpload(session.location)
+		return session.offset - start, 0, t.Errorf("Verify blob %s(%v) from %s/%s:%s failed, got %s(%v)",
+			b.Digest, b.Size, t.source.GetRegistry(), t.source.GetRepository(), t.source.GetTag(),
+			streamed, session.offset)
+	}
+	if err := t.destination.finishUpload(session.location, b.Digest); err != nil {
+		t.keepUpload(b.Digest, session)
+		return session.offset - start, 0, t.Errorf("Commit blob %s(%v) to %s/%s:%s failed: %v",
+			b.Digest, session.offset, t.destination.GetRegistry(), t.destination.GetRepository(), t.destination.GetTag(), err)
+	}
+	t.reportProgress(BlobProgress{Digest: b.Digest, Size: b.Size, Offset: session.offset, Done: true})
+	return session.offset - start, session.offset, nil
+}
+
+// resumeUpload returns the upload session interrupted by the last run and the hash of its accepted bytes,
+// nil if there is no such session or destination does not have the same bytes any more
+func (t *Task) resumeUpload(dgst digest.Digest) (*uploadSession, hash.Hash) {
+	t.uploadsMu.Lock()
+	session, ok := t.uploads[dgst]
+	delete(t.uploads, dgst)
+	t.uploadsMu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+
+	hasher := dgst.Algorithm().Hash()
+	offset, err := t.destination.uploadOffset(session.location)
+	if err == nil && offset != session.offset {
+		err = fmt.Errorf("destination accepted %d bytes, expected %d", offset, session.offset)
+	}
+	if err == nil {
+		err = hasher.(encoding.BinaryUnmarshaler).UnmarshalBinary(session.hashState)
+	}
+	if err != nil {
+		t.Infof("Cannot resume upload of blob %s to %s/%s, restart from the beginning: %v",
+			dgst, t.destination.GetRegistry(), t.destination.GetRepository(), err)
+		t.destination.cancelUpload(session.location)
+		return nil, nil
+	}
+	t.Infof("Resume upload of blob %s to %s/%s from %d bytes",
+		dgst, t.destination.GetRegistry(), t.destination.GetRepository(), session.offset)
+	return session, hasher
+}
+
+// keepUpload keeps an interrupted upload session for the next retry if any byte has been accepted
+func (t *Task) keepUpload(dgst digest.Digest, session *uploadSession) {
+	if session.offset == 0 || session.hashState == nil {
+		t.destination.cancelUpload(session.location)
+		return
+	}
+	t.uploadsMu.Lock()
+	defer t.uploadsMu.Unlock()
+	t.uploads[dgst] = session
+}
+
+// marshalHash returns the state of a hash to resume it later, nil if the hash does not support it
+func marshalHash(h hash.Hash) []byte {
+	marshaler, ok := h.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil
+	}
+	state, err := marshaler.MarshalBinary()
+	if err != nil {
+		return nil
+	}
+	return state
+}
+
+// limitedReader limits the read rate of a reader
+type limitedReader struct {
+	reader  io.Reader
+	limiter *rate.Limiter
+	ctx     context.Context
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if burst := r.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,370 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRegistry implements the token auth, range requests, chunked uploads, cross repository mounts,
+// manifests and referrers of a registry, blobs are pulled from repository src and pushed to repository dst by default
+type fakeRegistry struct {
+	server *httptest.Server
+	blobs  map[digest.Digest][]byte
+
+	mu      sync.Mutex
+	uploads map[string][]byte
+	// PATCH requests fail after failAfter chunks are accepted, 0 means never
+	failAfter int
+	chunks    int
+	ranges    []string
+	// blobs committed to dst
+	committed map[digest.Digest][]byte
+	// blobs committed to every destination repository, including dst
+	repositories map[string]map[digest.Digest][]byte
+	// number of blobs mounted from another repository
+	mounts int
+	// manifests by <repository>/<reference>
+	manifests map[string][]byte
+	// referrers of the subject digests, nil means the referrers api is not supported
+	referrers map[string][]imgspecv1.Descriptor
+	// the destination repositories support the referrers api
+	destinationReferrers bool
+}
+
+func newFakeRegistry(t *testing.T, blobs ...[]byte) *fakeRegistry {
+	r := &fakeRegistry{
+		blobs:     make(map[digest.Digest][]byte),
+		uploads:   make(map[string][]byte),
+		committed: make(map[digest.Digest][]byte),
+		manifests: make(map[string][]byte),
+	}
+	r.repositories = map[string]map[digest.Digest][]byte{"dst": r.committed}
+	for _, blob := range blobs {
+		r.blobs[digest.FromBytes(blob)] = blob
+	}
+	r.server = httptest.NewTLSServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *fakeRegistry) host() string {
+	return r.server.Listener.Addr().String()
+}
+
+// repository returns the blobs committed to a destination repository
+func (r *fakeRegistry) repository(name string) map[digest.Digest][]byte {
+	if r.repositories[name] == nil {
+		r.repositories[name] = make(map[digest.Digest][]byte)
+	}
+	return r.repositories[name]
+}
+
+func (r *fakeRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.URL.Path == "/token" {
+		if user, password, _ := req.BasicAuth(); user != "user" || password != "password" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"token": "token-%s"}`, strings.Join(req.URL.Query()["scope"], " "))
+		return
+	}
+	if !strings.HasPrefix(req.Header.Get("Authorization"), "Bearer token-repository:") {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake"`, r.server.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case req.URL.Path == "/v2/":
+	case strings.HasPrefix(req.URL.Path, "/v2/src/blobs/"):
+		blob, ok := r.blobs[digest.Digest(strings.TrimPrefix(req.URL.Path, "/v2/src/blobs/"))]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		r.ranges = append(r.ranges, req.Header.Get("Range"))
+		var start int
+		fmt.Sscanf(req.Header.Get("Range"), "bytes=%d-", &start)
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(blob[start:])
+	case strings.HasPrefix(req.URL.Path, "/v2/src/referrers/"):
+		if r.referrers == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		index := imgspecv1.Index{MediaType: imgspecv1.MediaTypeImageIndex, Manifests: r.referrers[strings.TrimPrefix(req.URL.Path, "/v2/src/referrers/")]}
+		index.SchemaVersion = 2
+		w.Header().Set("Content-Type", imgspecv1.MediaTypeImageIndex)
+		json.NewEncoder(w).Encode(index)
+	case strings.Contains(req.URL.Path, "/referrers/"):
+		if !r.destinationReferrers {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", imgspecv1.MediaTypeImageIndex)
+		fmt.Fprintf(w, `{"schemaVersion": 2, "mediaType": "%s", "manifests": []}`, imgspecv1.MediaTypeImageIndex)
+	case strings.Contains(req.URL.Path, "/manifests/"):
+		key := strings.Replace(strings.TrimPrefix(req.URL.Path, "/v2/"), "/manifests/", "/", 1)
+		switch req.Method {
+		case http.MethodGet:
+			m, ok := r.manifests[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", manifest.GuessMIMEType(m))
+			w.Write(m)
+		case http.MethodPut:
+			m, _ := io.ReadAll(req.Body)
+			r.manifests[key] = m
+			w.WriteHeader(http.StatusCreated)
+		}
+	case strings.Contains(req.URL.Path, "/blobs/sha256:") && req.Method == http.MethodHead:
+		repository, dgst, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/v2/"), "/blobs/")
+		blob, ok := r.repository(repository)[digest.Digest(dgst)]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(blob)))
+	case strings.HasSuffix(req.URL.Path, "/blobs/uploads/") && req.Method == http.MethodPost:
+		repository := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/v2/"), "/blobs/uploads/")
+		dgst, from := digest.Digest(req.URL.Query().Get("mount")), req.URL.Query().Get("from")
+		if blob, ok := r.repository(from)[dgst]; ok && from != "" &&
+			strings.Contains(req.Header.Get("Authorization"), "repository:"+from+":pull") {
+			r.repository(repository)[dgst] = blob
+			r.mounts++
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		id := strconv.Itoa(len(r.uploads))
+		r.uploads[id] = nil
+		w.Header().Set("Location", "/v2/"+repository+"/blobs/uploads/"+id)
+		w.WriteHeader(http.StatusAccepted)
+	case strings.Contains(req.URL.Path, "/blobs/uploads/"):
+		repository, id, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/v2/"), "/blobs/uploads/")
+		data := r.uploads[id]
+		switch req.Method {
+		case http.MethodGet:
+			w.Header().Set("Range", fmt.Sprintf("0-%d", len(data)-1))
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodPatch:
+			if r.failAfter > 0 && r.chunks >= r.failAfter {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if !strings.HasPrefix(req.Header.Get("Content-Range"), fmt.Sprintf("%d-", len(data))) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			chunk, _ := io.ReadAll(req.Body)
+			r.uploads[id] = append(data, chunk...)
+			r.chunks++
+			w.Header().Set("Location", req.URL.Path)
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodPut:
+			dgst := digest.Digest(req.URL.Query().Get("digest"))
+			if digest.FromBytes(data) != dgst {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			r.repository(repository)[dgst] = data
+			delete(r.uploads, id)
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			delete(r.uploads, id)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// fakeImageSource only serves blobs and manifests, manifest is the manifest of the tag
+type fakeImageSource struct {
+	types.ImageSource
+	blobs     map[digest.Digest][]byte
+	manifest  []byte
+	manifests map[digest.Digest][]byte
+}
+
+func (s *fakeImageSource) GetBlob(_ context.Context, info types.BlobInfo, _ types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	blob, ok := s.blobs[info.Digest]
+	if !ok {
+		return nil, 0, fmt.Errorf("blob %s not found", info.Digest)
+	}
+	return io.NopCloser(bytes.NewReader(blob)), int64(len(blob)), nil
+}
+
+func (s *fakeImageSource) GetManifest(_ context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	if instanceDigest == nil {
+		return s.manifest, manifest.GuessMIMEType(s.manifest), nil
+	}
+	m, ok := s.manifests[*instanceDigest]
+	if !ok {
+		return nil, "", fmt.Errorf("manifest %s not found", instanceDigest)
+	}
+	return m, manifest.GuessMIMEType(m), nil
+}
+
+// fakeImageDestination only checks the blobs committed to a repository of the fake registry
+type fakeImageDestination struct {
+	types.ImageDestination
+	registry   *fakeRegistry
+	repository string
+}
+
+func (d *fakeImageDestination) TryReusingBlob(_ context.Context, info types.BlobInfo, _ types.BlobInfoCache, _ bool) (bool, types.BlobInfo, error) {
+	d.registry.mu.Lock()
+	defer d.registry.mu.Unlock()
+	_, ok := d.registry.repository(d.repository)[info.Digest]
+	return ok, info, nil
+}
+
+func newFakeTask(r *fakeRegistry) *Task {
+	return newFakeTaskTo(r, "dst")
+}
+
+// newFakeTaskTo creates a task which syncs repository src to a destination repository of the fake registry
+func newFakeTaskTo(r *fakeRegistry, repository string) *Task {
+	sysctx := &types.SystemContext{
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		DockerAuthConfig:            &types.DockerAuthConfig{Username: "user", Password: "password"},
+	}
+	source := &ImageSource{
+		source:     &fakeImageSource{blobs: r.blobs},
+		ctx:        context.Background(),
+		client:     newRegistryClient(r.host(), "src", sysctx, "pull"),
+		registry:   r.host(),
+		repository: "src",
+	}
+	destination := &ImageDestination{
+		destination: &fakeImageDestination{registry: r, repository: repository},
+		ctx:         context.Background(),
+		client:      newRegistryClient(r.host(), repository, sysctx, "pull,push"),
+		registry:    r.host(),
+		repository:  repository,
+	}
+	task := NewTask(source, destination, nil, nil, nil)
+	task.chunkSize = 4
+	return task
+}
+
+func TestPushBlobResume(t *testing.T) {
+	blob := []byte("0123456789")
+	r := newFakeRegistry(t, blob)
+	r.failAfter = 2
+	task := newFakeTask(r)
+	var events []BlobProgress
+	task.SetProgress(func(p BlobProgress) { events = append(events, p) })
+
+	info := types.BlobInfo{Digest: digest.FromBytes(blob), Size: int64(len(blob))}
+	transferred, _, err := task.pushBlob(info)
+	assert.Error(t, err)
+	assert.Equal(t, int64(8), transferred)
+	assert.Equal(t, int64(8), task.uploads[info.Digest].offset)
+
+	// the next retry resumes from the accepted bytes
+	r.failAfter = 0
+	transferred, size, err := task.pushBlob(info)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), transferred)
+	assert.Equal(t, int64(10), size)
+	assert.Equal(t, blob, r.committed[info.Digest])
+	assert.Equal(t, []string{"bytes=8-"}, r.ranges)
+	assert.Empty(t, task.uploads)
+
+	assert.Equal(t, []BlobProgress{
+		{Digest: info.Digest, Size: 10, Offset: 4, Written: 4},
+		{Digest: info.Digest, Size: 10, Offset: 8, Written: 4},
+		{Digest: info.Digest, Size: 10, Offset: 10, Written: 2},
+		{Digest: info.Digest, Size: 10, Offset: 10, Done: true},
+	}, events)
+}
+
+func TestPushBlobVerify(t *testing.T) {
+	r := newFakeRegistry(t)
+	// the source serves content which does not match the digest
+	info := types.BlobInfo{Digest: digest.FromString("something else"), Size: 10}
+	r.blobs[info.Digest] = []byte("0123456789")
+	task := newFakeTask(r)
+	task.SetBandwidth(1 << 20)
+	task.limiter = task.newLimiter()
+
+	_, _, err := task.pushBlob(info)
+	assert.ErrorContains(t, err, "Verify blob")
+	assert.Empty(t, r.committed)
+	assert.Empty(t, r.uploads, "the upload session should be cancelled")
+	assert.Empty(t, task.uploads)
+}
+
+func TestPushBlobContext(t *testing.T) {
+	blob := []byte("0123456789")
+	r := newFakeRegistry(t, blob)
+	task := newFakeTask(r)
+	ctx, cancel := context.WithCancel(context.Background())
+	task.SetContext(ctx)
+	// the replica loses the leadership, the blobs are not pushed any more
+	cancel()
+
+	restore := task.withContext()
+	_, _, err := task.pushBlob(types.BlobInfo{Digest: digest.FromBytes(blob), Size: int64(len(blob))})
+	restore()
+	assert.ErrorContains(t, err, context.Canceled.Error())
+	assert.Empty(t, r.committed)
+	assert.NoError(t, task.destination.ctx.Err(), "the context of destination is restored after run")
+}
+
+func TestPlainHTTPRegistry(t *testing.T) {
+	blob := []byte("0123456789")
+	r := newFakeRegistry(t, blob)
+	r.server.Close()
+	r.server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.server.Close)
+
+	// an insecure registry falls back to plain http
+	task := newFakeTask(r)
+	info := types.BlobInfo{Digest: digest.FromBytes(blob), Size: int64(len(blob))}
+	_, _, err := task.pushBlob(info)
+	assert.NoError(t, err)
+	assert.Equal(t, blob, r.committed[info.Digest])
+
+	// a secure registry does not
+	client := newRegistryClient(r.host(), "dst", &types.SystemContext{
+		DockerAuthConfig: &types.DockerAuthConfig{Username: "user", Password: "password"},
+	}, "pull")
+	_, err = client.blobSize(context.Background(), info.Digest)
+	assert.Error(t, err)
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry",scope="repository:a/b:pull,push"`)
+	assert.Equal(t, "Bearer", scheme)
+	assert.Equal(t, map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry",
+		"scope":   "repository:a/b:pull,push",
+	}, params)
+
+	scheme, params = parseChallenge(`Basic realm=registry`)
+	assert.Equal(t, "Basic", scheme)
+	assert.Equal(t, map[string]string{"realm": "registry"}, params)
+}
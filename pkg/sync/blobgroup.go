@@ -0,0 +1,61 @@
+package sync
+
+import (
+	"context"
+	"sync"
+)
+
+// BlobGroup deduplicates the blob transfers of the tasks in the same round,
+// a blob is transferred to a destination registry only once and the other tasks wait for it,
+// then mount it from the repository it has been transferred to
+type BlobGroup struct {
+	mu    sync.Mutex
+	blobs map[string]*blobTransfer
+}
+
+type blobTransfer struct {
+	done chan struct{}
+	err  error
+	// the repository the blob is transferred to
+	repository string
+}
+
+// NewBlobGroup creates a BlobGroup
+func NewBlobGroup() *BlobGroup {
+	return &BlobGroup{blobs: make(map[string]*blobTransfer)}
+}
+
+// Do calls fn to transfer the blob of key to repository unless it is being or has been transferred by another call,
+// it returns true if fn is called by this call, otherwise the repository the other call transferred the blob to.
+// If the other call fails, fn is called to try again.
+func (g *BlobGroup) Do(ctx context.Context, key, repository string, fn func() error) (bool, string, error) {
+	for {
+		g.mu.Lock()
+		transfer, ok := g.blobs[key]
+		if !ok {
+			transfer = &blobTransfer{done: make(chan struct{}), repository: repository}
+			g.blobs[key] = transfer
+			g.mu.Unlock()
+
+			transfer.err = fn()
+			if transfer.err != nil {
+				// the waiting calls and the later tasks try again
+				g.mu.Lock()
+				delete(g.blobs, key)
+				g.mu.Unlock()
+			}
+			close(transfer.done)
+			return true, repository, transfer.err
+		}
+		g.mu.Unlock()
+
+		select {
+		case <-transfer.done:
+		case <-ctx.Done():
+			return false, "", ctx.Err()
+		}
+		if transfer.err == nil {
+			return false, transfer.repository, nil
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlobGroup(t *testing.T) {
+	g := NewBlobGroup()
+
+	release := make(chan struct{})
+	var calls atomic.Int32
+	transfer := func() error {
+		calls.Add(1)
+		<-release
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	owners := make([]bool, 3)
+	for i := range owners {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			owner, _, err := g.Do(context.Background(), "registry@sha256:a", "repo", transfer)
+			assert.NoError(t, err)
+			owners[i] = owner
+		}()
+	}
+	assert.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// only one call transfers the blob, the others wait for it
+	assert.Equal(t, int32(1), calls.Load())
+	assert.ElementsMatch(t, []bool{true, false, false}, owners)
+
+	// a transferred blob is not transferred again in the same round
+	owner, from, err := g.Do(context.Background(), "registry@sha256:a", "other", transfer)
+	assert.NoError(t, err)
+	assert.False(t, owner)
+	assert.Equal(t, "repo", from, "the other repositories mount the blob from the repository it is transferred to")
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestBlobGroupFailure(t *testing.T) {
+	g := NewBlobGroup()
+
+	owner, _, err := g.Do(context.Background(), "blob", "repo", func() error { return errors.New("broken pipe") })
+	assert.True(t, owner)
+	assert.EqualError(t, err, "broken pipe")
+
+	// a failed transfer is tried again by the next call
+	owner, _, err = g.Do(context.Background(), "blob", "repo", func() error { return nil })
+	assert.True(t, owner)
+	assert.NoError(t, err)
+
+	// waiting is cancelled with the context
+	release := make(chan struct{})
+	defer close(release)
+	go g.Do(context.Background(), "slow", "repo", func() error { <-release; return nil })
+	assert.Eventually(t, func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return g.blobs["slow"] != nil
+	}, time.Second, time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	owner, _, err = g.Do(ctx, "slow", "repo", func() error { return nil })
+	assert.False(t, owner)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestBlobGroupRepositories(t *testing.T) {
+	blob := []byte("0123456789")
+	r := newFakeRegistry(t, blob)
+	info := types.BlobInfo{Digest: digest.FromBytes(blob), Size: int64(len(blob))}
+	blobs := NewBlobGroup()
+	var statsMu sync.Mutex
+
+	first := newFakeTaskTo(r, "dst")
+	first.SetBlobGroup(blobs)
+	assert.NoError(t, first.transferBlob(info, &statsMu))
+	assert.Equal(t, 1, first.stats.BlobsTransferred)
+
+	// another repository of the same registry mounts the blob instead of streaming it again
+	second := newFakeTaskTo(r, "dst2")
+	second.SetBlobGroup(blobs)
+	assert.NoError(t, second.transferBlob(info, &statsMu))
+	assert.Equal(t, blob, r.repository("dst2")[info.Digest])
+	assert.Equal(t, 1, r.mounts)
+	assert.Equal(t, 1, second.stats.BlobsSkipped)
+	assert.Equal(t, int64(0), second.stats.BytesTransferred)
+
+	// the blob is pushed if the registry does not mount it
+	delete(r.repository("dst"), info.Digest)
+	third := newFakeTaskTo(r, "dst3")
+	third.SetBlobGroup(blobs)
+	assert.NoError(t, third.transferBlob(info, &statsMu))
+	assert.Equal(t, blob, r.repository("dst3")[info.Digest])
+	assert.Equal(t, 1, r.mounts)
+	assert.Equal(t, 1, third.stats.BlobsTransferred)
+	assert.Equal(t, int64(len(blob)), third.stats.BytesTransferred)
+	assert.Empty(t, r.uploads, "the upload session opened by the mount request should be cancelled")
+}
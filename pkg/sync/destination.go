@@ -1,14 +1,19 @@
 package sync
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"aliyun-images-syncer/pkg/tools"
 
 	"github.com/containers/image/v5/docker"
 	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
 )
 
 // ImageDestination is a reference of a remote image we will push to
@@ -17,6 +22,8 @@ type ImageDestination struct {
 	destination    types.ImageDestination
 	ctx            context.Context
 	sysctx         *types.SystemContext
+	// used for chunked upload sessions
+	client *registryClient
 
 	// destination image description
 	registry   string
@@ -83,6 +90,7 @@ func NewImageDestination(registry, repository, tag, username, password string, i
 		destination:    rawDestination,
 		ctx:            ctx,
 		sysctx:         sysctx,
+		client:         newRegistryClient(registry, repository, sysctx, "pull,push"),
 		registry:       registry,
 		repository:     repository,
 		tag:            tag,
@@ -94,17 +102,144 @@ func (i *ImageDestination) PushManifest(manifestByte []byte) error {
 	return i.destination.PutManifest(i.ctx, manifestByte, nil)
 }
 
-// PutABlob push a blob to destination image
-func (i *ImageDestination) PutABlob(blob io.ReadCloser, blobInfo types.BlobInfo) error {
-	_, err := i.destination.PutBlob(i.ctx, blob, types.BlobInfo{
-		Digest: blobInfo.Digest,
-		Size:   blobInfo.Size,
-	}, NoCache, true)
+// startUpload opens an upload session of a blob and returns its location
+func (i *ImageDestination) startUpload() (string, error) {
+	req, err := http.NewRequestWithContext(i.ctx, http.MethodPost, i.client.uploadURL(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := i.client.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return "", responseError(resp, "start upload")
+	}
+	return i.client.location(resp)
+}
+
+// mountBlob mounts a blob from another repository of the same registry without transferring it,
+// an error is returned if the registry does not mount it
+func (i *ImageDestination) mountBlob(dgst digest.Digest, from string) error {
+	authorization, err := i.client.mountAuthorization(i.ctx, from)
+	if err != nil {
+		return err
+	}
+	query := url.Values{"mount": {dgst.String()}, "from": {from}}
+	req, err := http.NewRequestWithContext(i.ctx, http.MethodPost, i.client.uploadURL()+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	i.client.resolveScheme(req)
+	resp, err := i.client.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return nil
+	case http.StatusAccepted:
+		// the registry opens an upload session instead, it is not used
+		if location, err := i.client.location(resp); err == nil {
+			i.cancelUpload(location)
+		}
+		return fmt.Errorf("mount blob %s: registry opens an upload session", dgst)
+	default:
+		return responseError(resp, "mount blob "+dgst.String())
+	}
+}
 
-	// io.ReadCloser need to be close
-	defer blob.Close()
+// uploadOffset returns the number of bytes accepted by an upload session
+func (i *ImageDestination) uploadOffset(location string) (int64, error) {
+	req, err := http.NewRequestWithContext(i.ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := i.client.do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return 0, responseError(resp, "get upload status")
+	}
+	return parseUploadRange(resp.Header.Get("Range"))
+}
 
-	return err
+// uploadChunk appends a chunk at offset to an upload session and returns the location of the next request
+func (i *ImageDestination) uploadChunk(location string, chunk []byte, offset int64) (string, error) {
+	req, err := http.NewRequestWithContext(i.ctx, http.MethodPatch, location, bytes.NewReader(chunk))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+int64(len(chunk))-1))
+	resp, err := i.client.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return "", responseError(resp, "upload chunk")
+	}
+	return i.client.location(resp)
+}
+
+// finishUpload completes an upload session, the registry verifies the digest of all the uploaded bytes
+func (i *ImageDestination) finishUpload(location string, dgst digest.Digest) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	query := u.Query()
+	query.Set("digest", dgst.String())
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(i.ctx, http.MethodPut, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := i.client.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(resp, "finish upload")
+	}
+	return nil
+}
+
+// cancelUpload deletes an upload session, errors are ignored because the registry cleans up stale sessions
+func (i *ImageDestination) cancelUpload(location string) {
+	req, err := http.NewRequestWithContext(i.ctx, http.MethodDelete, location, nil)
+	if err != nil {
+		return
+	}
+	if resp, err := i.client.do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// parseUploadRange parses the Range header "0-<last byte>" of an upload session into the number of accepted bytes
+func parseUploadRange(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	_, last, ok := strings.Cut(strings.TrimPrefix(value, "bytes="), "-")
+	if !ok {
+		return 0, fmt.Errorf("invalid upload range %q", value)
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid upload range %q", value)
+	}
+	return end + 1, nil
 }
 
 // CheckBlobExist checks if a blob exist for destination and reuse exist blobs
@@ -6,23 +6,28 @@ import (
 	"strings"
 
 	"github.com/containers/image/v5/manifest"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/tidwall/gjson"
 )
 
-// ManifestHandler expends the ability of handling manifest list in schema2, but it's not finished yet
+// ManifestHandler expends the ability of handling manifest list in schema2 and image index in OCI,
 // return the digest array of manifests in the manifest list if exist.
+// parent is the manifest list or image index of a sub-manifest, nil for the top level manifest.
+// If some manifests of a list are filtered by os or architecture, the filtered manifest.List is returned.
 func ManifestHandler(manifestBytes []byte, manifestType string, osFilterList, archFilterList []string,
-	i *ImageSource, parent *manifest.Schema2List) ([]manifest.Manifest, interface{}, error) {
+	i *ImageSource, parent manifest.List) ([]manifest.Manifest, interface{}, error) {
 	var manifestInfoSlice []manifest.Manifest
 
-	if manifestType == manifest.DockerV2Schema2MediaType {
-		manifestInfo, err := manifest.Schema2FromManifest(manifestBytes)
+	if manifestType == manifest.DockerV2Schema2MediaType || manifestType == imgspecv1.MediaTypeImageManifest {
+		manifestInfo, err := manifest.FromBlob(manifestBytes, manifestType)
 		if err != nil {
 			return nil, nil, err
 		}
 
 		// platform info stored in config blob
-		if parent == nil && manifestInfo.ConfigInfo().Digest != "" {
+		// the config of an artifact is not an image config and has no platform
+		if parent == nil && manifestInfo.ConfigInfo().Digest != "" && isImageConfig(manifestInfo.ConfigInfo().MediaType) {
 			blob, _, err := i.GetABlob(manifestInfo.ConfigInfo())
 			if err != nil {
 				return nil, nil, err
@@ -32,10 +37,10 @@ func ManifestHandler(manifestBytes []byte, manifestType string, osFilterList, ar
 			if err != nil {
 				return nil, nil, err
 			}
-			results := gjson.GetManyBytes(bytes, "architecture", "os")
+			results := gjson.GetManyBytes(bytes, "architecture", "os", "variant", `os\.version`)
 
-			if !platformValidate(osFilterList, archFilterList,
-				&manifest.Schema2PlatformSpec{Architecture: results[0].String(), OS: results[1].String()}) {
+			if !platformValidate(osFilterList, archFilterList, &manifest.Schema2PlatformSpec{
+				Architecture: results[0].String(), OS: results[1].String(), Variant: results[2].String(), OSVersion: results[3].String()}) {
 				return manifestInfoSlice, manifestInfo, nil
 			}
 		}
@@ -56,28 +61,29 @@ func ManifestHandler(manifestBytes []byte, manifestType string, osFilterList, ar
 
 		manifestInfoSlice = append(manifestInfoSlice, manifestInfo)
 		return manifestInfoSlice, nil, nil
-	} else if manifestType == manifest.DockerV2ListMediaType {
-		manifestSchemaListInfo, err := manifest.Schema2ListFromManifest(manifestBytes)
+	} else if manifest.MIMETypeIsMultiImage(manifestType) {
+		// Docker manifest list or OCI image index
+		list, err := manifest.ListFromBlob(manifestBytes, manifestType)
 		if err != nil {
 			return nil, nil, err
 		}
 
-		var nm []manifest.Schema2ManifestDescriptor
-
-		for _, manifestDescriptorElem := range manifestSchemaListInfo.Manifests {
+		var selected []int
+		digests, platforms := listPlatforms(list)
+		for index, manifestDigest := range digests {
 			// select os and arch
-			if !platformValidate(osFilterList, archFilterList, &manifestDescriptorElem.Platform) {
+			if !platformValidate(osFilterList, archFilterList, &platforms[index]) {
 				continue
 			}
 
-			nm = append(nm, manifestDescriptorElem)
-			manifestByte, manifestType, err := i.source.GetManifest(i.ctx, &manifestDescriptorElem.Digest)
+			selected = append(selected, index)
+			manifestByte, manifestType, err := i.source.GetManifest(i.ctx, &manifestDigest)
 			if err != nil {
 				return nil, nil, err
 			}
 
 			platformSpecManifest, _, err := ManifestHandler(manifestByte, manifestType,
-				archFilterList, osFilterList, i, manifestSchemaListInfo)
+				osFilterList, archFilterList, i, list)
 			if err != nil {
 				return nil, nil, err
 			}
@@ -85,10 +91,10 @@ func ManifestHandler(manifestBytes []byte, manifestType string, osFilterList, ar
 			manifestInfoSlice = append(manifestInfoSlice, platformSpecManifest...)
 		}
 
-		// return a new Schema2List
-		if len(nm) != len(manifestSchemaListInfo.Manifests) {
-			manifestSchemaListInfo.Manifests = nm
-			return manifestInfoSlice, manifestSchemaListInfo, nil
+		// return a new list with the selected manifests
+		if len(selected) != len(digests) {
+			filterList(list, selected)
+			return manifestInfoSlice, list, nil
 		}
 
 		return manifestInfoSlice, nil, nil
@@ -97,6 +103,84 @@ func ManifestHandler(manifestBytes []byte, manifestType string, osFilterList, ar
 	return nil, nil, fmt.Errorf("unsupported manifest type: %v", manifestType)
 }
 
+// listPlatforms returns the digest and platform of every manifest in a Docker manifest list or an OCI image index
+func listPlatforms(list manifest.List) ([]digest.Digest, []manifest.Schema2PlatformSpec) {
+	var digests []digest.Digest
+	var platforms []manifest.Schema2PlatformSpec
+	switch l := list.(type) {
+	case *manifest.Schema2List:
+		for _, m := range l.Manifests {
+			digests = append(digests, m.Digest)
+			platforms = append(platforms, m.Platform)
+		}
+	case *manifest.OCI1Index:
+		for _, m := range l.Manifests {
+			digests = append(digests, m.Digest)
+			// platform is optional in an image index, a manifest without platform is never filtered
+			var platform manifest.Schema2PlatformSpec
+			if m.Platform != nil {
+				platform = manifest.Schema2PlatformSpec{
+					Architecture: m.Platform.Architecture,
+					OS:           m.Platform.OS,
+					OSVersion:    m.Platform.OSVersion,
+					OSFeatures:   m.Platform.OSFeatures,
+					Variant:      m.Platform.Variant,
+				}
+			}
+			platforms = append(platforms, platform)
+		}
+	}
+	return digests, platforms
+}
+
+// filterList keeps the manifests of a Docker manifest list or an OCI image index at the selected indexes
+func filterList(list manifest.List, selected []int) {
+	switch l := list.(type) {
+	case *manifest.Schema2List:
+		manifests := make([]manifest.Schema2ManifestDescriptor, 0, len(selected))
+		for _, index := range selected {
+			manifests = append(manifests, l.Manifests[index])
+		}
+		l.Manifests = manifests
+	case *manifest.OCI1Index:
+		manifests := make([]imgspecv1.Descriptor, 0, len(selected))
+		for _, index := range selected {
+			manifests = append(manifests, l.Manifests[index])
+		}
+		l.Manifests = manifests
+	}
+}
+
+// platformString formats a platform as os[:osversion]/architecture[/variant]
+func platformString(platform manifest.Schema2PlatformSpec) string {
+	s := platform.OS
+	if platform.OSVersion != "" {
+		s += ":" + platform.OSVersion
+	}
+	s += "/" + platform.Architecture
+	if platform.Variant != "" {
+		s += "/" + platform.Variant
+	}
+	return s
+}
+
+// removedPlatforms returns the platforms of the original list which are not in the filtered list
+func removedPlatforms(original, filtered manifest.List) []string {
+	kept := make(map[digest.Digest]bool)
+	digests, _ := listPlatforms(filtered)
+	for _, d := range digests {
+		kept[d] = true
+	}
+	var removed []string
+	digests, platforms := listPlatforms(original)
+	for index, d := range digests {
+		if !kept[d] {
+			removed = append(removed, platformString(platforms[index]))
+		}
+	}
+	return removed
+}
+
 // compare first:second to pat, second is optional
 func colonMatch(pat string, first string, second string) bool {
 	if strings.Index(pat, first) != 0 {
@@ -0,0 +1,113 @@
+package sync
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+const ociManifestAmd64 = `{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.oci.image.manifest.v1+json",
+  "config": {"mediaType": "application/vnd.oci.image.config.v1+json", "digest": "sha256:1111111111111111111111111111111111111111111111111111111111111111", "size": 100},
+  "layers": [{"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip", "digest": "sha256:2222222222222222222222222222222222222222222222222222222222222222", "size": 200}]
+}`
+
+const ociManifestArm64 = `{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.oci.image.manifest.v1+json",
+  "config": {"mediaType": "application/vnd.oci.image.config.v1+json", "digest": "sha256:3333333333333333333333333333333333333333333333333333333333333333", "size": 100},
+  "layers": [{"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip", "digest": "sha256:4444444444444444444444444444444444444444444444444444444444444444", "size": 300}]
+}`
+
+func ociIndex() string {
+	return `{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.oci.image.index.v1+json",
+  "manifests": [
+    {"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "` + digest.FromString(ociManifestAmd64).String() + `", "size": 400, "platform": {"architecture": "amd64", "os": "linux"}},
+    {"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "` + digest.FromString(ociManifestArm64).String() + `", "size": 400, "platform": {"architecture": "arm64", "os": "linux", "variant": "v8"}}
+  ]
+}`
+}
+
+func newManifestSource() *ImageSource {
+	return &ImageSource{
+		source: &fakeImageSource{manifests: map[digest.Digest][]byte{
+			digest.FromString(ociManifestAmd64): []byte(ociManifestAmd64),
+			digest.FromString(ociManifestArm64): []byte(ociManifestArm64),
+		}},
+		ctx: context.Background(),
+	}
+}
+
+func TestManifestHandlerOCIIndex(t *testing.T) {
+	infos, filtered, err := ManifestHandler([]byte(ociIndex()), imgspecv1.MediaTypeImageIndex, nil, nil, newManifestSource(), nil)
+	assert.NoError(t, err)
+	assert.Len(t, infos, 2)
+	assert.Nil(t, filtered)
+
+	infos, filtered, err = ManifestHandler([]byte(ociIndex()), imgspecv1.MediaTypeImageIndex, nil, []string{"arm64:v8"}, newManifestSource(), nil)
+	assert.NoError(t, err)
+	if assert.Len(t, infos, 1) {
+		assert.Equal(t, "sha256:3333333333333333333333333333333333333333333333333333333333333333", infos[0].ConfigInfo().Digest.String())
+	}
+
+	// the filtered index keeps its media type and only the selected platform
+	index, ok := filtered.(*manifest.OCI1Index)
+	if assert.True(t, ok) {
+		assert.Len(t, index.Manifests, 1)
+		assert.Equal(t, digest.FromString(ociManifestArm64), index.Manifests[0].Digest)
+		serialized, err := index.Serialize()
+		assert.NoError(t, err)
+		assert.Equal(t, imgspecv1.MediaTypeImageIndex, manifest.GuessMIMEType(serialized))
+	}
+}
+
+func TestListPlatforms(t *testing.T) {
+	list, err := manifest.ListFromBlob([]byte(ociIndex()), imgspecv1.MediaTypeImageIndex)
+	assert.NoError(t, err)
+	digests, platforms := listPlatforms(list)
+	assert.Equal(t, []digest.Digest{digest.FromString(ociManifestAmd64), digest.FromString(ociManifestArm64)}, digests)
+	assert.Equal(t, manifest.Schema2PlatformSpec{Architecture: "arm64", OS: "linux", Variant: "v8"}, platforms[1])
+
+	filterList(list, []int{0})
+	digests, _ = listPlatforms(list)
+	assert.Equal(t, []digest.Digest{digest.FromString(ociManifestAmd64)}, digests)
+}
+
+func TestManifestHandlerPlatformFilter(t *testing.T) {
+	// os and architecture are both applied to the sub-manifests
+	infos, filtered, err := ManifestHandler([]byte(ociIndex()), imgspecv1.MediaTypeImageIndex, []string{"linux"}, []string{"amd64"}, newManifestSource(), nil)
+	assert.NoError(t, err)
+	assert.Len(t, infos, 1)
+	original, err := manifest.ListFromBlob([]byte(ociIndex()), imgspecv1.MediaTypeImageIndex)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"linux/arm64/v8"}, removedPlatforms(original, filtered.(manifest.List)))
+
+	infos, _, err = ManifestHandler([]byte(ociIndex()), imgspecv1.MediaTypeImageIndex, []string{"windows"}, nil, newManifestSource(), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, infos)
+}
+
+func TestPlatformValidate(t *testing.T) {
+	arm64 := &manifest.Schema2PlatformSpec{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	assert.True(t, platformValidate(nil, []string{"arm64"}, arm64))
+	assert.True(t, platformValidate([]string{"linux"}, []string{"arm64:v8"}, arm64))
+	assert.False(t, platformValidate(nil, []string{"arm64:v7"}, arm64))
+	assert.False(t, platformValidate(nil, []string{"arm"}, arm64))
+
+	windows := &manifest.Schema2PlatformSpec{OS: "windows", OSVersion: "10.0.17763.1234", Architecture: "amd64"}
+	assert.True(t, platformValidate([]string{"windows:10.0.17763.1234"}, nil, windows))
+	assert.False(t, platformValidate([]string{"windows:10.0.20348.1"}, nil, windows))
+	assert.Equal(t, "windows:10.0.17763.1234/amd64", platformString(*windows))
+}
+
+func TestManifestHandlerUnsupported(t *testing.T) {
+	_, _, err := ManifestHandler([]byte(`{}`), "application/vnd.unknown", nil, nil, newManifestSource(), nil)
+	assert.EqualError(t, err, "unsupported manifest type: application/vnd.unknown")
+}
@@ -0,0 +1,296 @@
+package sync
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// cosignTagSuffixes are the tags cosign attaches to an image, the tag is "<algorithm>-<hex>.<suffix>"
+var cosignTagSuffixes = []string{"sig", "att", "sbom"}
+
+// ReferrersOptions selects the artifacts attached to an image which are synced with it
+type ReferrersOptions struct {
+	// glob patterns of artifact types, such as "application/vnd.dev.cosign.*", empty means all
+	ArtifactTypes []string `json:"artifactTypes" yaml:"artifactTypes"`
+}
+
+// Match checks if an artifact type is selected
+func (o *ReferrersOptions) Match(artifactType string) bool {
+	return len(o.ArtifactTypes) == 0 || matchAny(o.ArtifactTypes, artifactType)
+}
+
+// SetReferrers syncs the signatures, SBOMs and attestations of every synced manifest, nil means disabled
+func (t *Task) SetReferrers(options *ReferrersOptions) {
+	t.referrers = options
+}
+
+// referrer is a manifest attached to a subject manifest
+type referrer struct {
+	// reference pushed to destination, the tag of cosign artifacts or the digest of OCI referrers
+	reference    string
+	artifactType string
+	manifest     []byte
+	mediaType    string
+	// descriptor of an OCI referrer in the referrers index, nil for cosign artifacts found by tag
+	descriptor *imgspecv1.Descriptor
+}
+
+// artifactManifest contains the fields of image manifests and artifact manifests needed to copy an artifact
+type artifactManifest struct {
+	MediaType    string                 `json:"mediaType"`
+	ArtifactType string                 `json:"artifactType"`
+	Config       *imgspecv1.Descriptor  `json:"config"`
+	Layers       []imgspecv1.Descriptor `json:"layers"`
+	Blobs        []imgspecv1.Descriptor `json:"blobs"`
+}
+
+// artifactType returns the artifactType field, the config media type if it is not an image config,
+// or the media type of the first layer as cosign does
+func (m *artifactManifest) artifactType() string {
+	if m.ArtifactType != "" {
+		return m.ArtifactType
+	}
+	if m.Config != nil && m.Config.MediaType != "" && !isImageConfig(m.Config.MediaType) {
+		return m.Config.MediaType
+	}
+	if len(m.Layers) != 0 {
+		return m.Layers[0].MediaType
+	}
+	return m.MediaType
+}
+
+// blobInfos returns the config and all the blobs of an artifact
+func (m *artifactManifest) blobInfos() []types.BlobInfo {
+	var descriptors []imgspecv1.Descriptor
+	if m.Config != nil && m.Config.Digest != "" {
+		descriptors = append(descriptors, *m.Config)
+	}
+	descriptors = append(descriptors, m.Layers...)
+	descriptors = append(descriptors, m.Blobs...)
+	blobInfos := make([]types.BlobInfo, 0, len(descriptors))
+	for _, d := range descriptors {
+		blobInfos = append(blobInfos, manifest.BlobInfoFromOCI1Descriptor(d))
+	}
+	return blobInfos
+}
+
+// referrerSubjects returns the digests of the pushed manifests whose referrers are synced,
+// a filtered manifest list is not a subject because the destination has a different digest
+func (t *Task) referrerSubjects(resolved *resolvedManifest) []digest.Digest {
+	var subjects []digest.Digest
+	if t.stats.SourceDigest != "" && t.stats.SourceDigest == t.stats.DestinationDigest {
+		subjects = append(subjects, digest.Digest(t.stats.SourceDigest))
+	}
+	if !manifest.MIMETypeIsMultiImage(resolved.mediaType) {
+		return subjects
+	}
+	list, ok := resolved.filtered.(manifest.List)
+	if !ok {
+		var err error
+		if list, err = manifest.ListFromBlob(resolved.bytes, resolved.mediaType); err != nil {
+			return subjects
+		}
+	}
+	digests, _ := listPlatforms(list)
+	return append(subjects, digests...)
+}
+
+// syncReferrers copies the selected referrers of every subject from source to destination
+func (t *Task) syncReferrers(subjects []digest.Digest) error {
+	if t.referrers == nil {
+		return nil
+	}
+	var statsMu sync.Mutex
+	for _, subject := range subjects {
+		referrers, err := t.discoverReferrers(subject)
+		if err != nil {
+			return t.Errorf("Discover referrers of %s in %s/%s error: %v",
+				subject, t.source.GetRegistry(), t.source.GetRepository(), err)
+		}
+		var copied []imgspecv1.Descriptor
+		for _, r := range referrers {
+			if !t.referrers.Match(r.artifactType) {
+				continue
+			}
+			ok, err := t.copyReferrer(r, &statsMu)
+			if err != nil {
+				return err
+			}
+			if ok && r.descriptor != nil {
+				copied = append(copied, *r.descriptor)
+			}
+		}
+		if err := t.putReferrersIndex(subject, copied); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putReferrersIndex adds the copied OCI referrers to the referrers index of the tag schema
+// if the destination registry does not support the referrers api, clients like cosign and notation find them by the tag
+func (t *Task) putReferrersIndex(subject digest.Digest, copied []imgspecv1.Descriptor) error {
+	if len(copied) == 0 {
+		return nil
+	}
+	client, ctx := t.destination.client, t.destination.ctx
+	_, err := client.referrers(ctx, subject)
+	if err == nil {
+		// the registry indexes the referrers by their subject
+		return nil
+	}
+	if !errors.Is(err, errReferrersUnsupported) {
+		return t.Errorf("Get referrers of %s from %s/%s error: %v",
+			subject, t.destination.GetRegistry(), t.destination.GetRepository(), err)
+	}
+
+	tag := strings.Replace(subject.String(), ":", "-", 1)
+	existing, _, err := client.getManifest(ctx, tag)
+	if errors.Is(err, errManifestNotFound) {
+		existing, err = nil, nil
+	}
+	if err != nil {
+		return t.Errorf("Get referrers index %s from %s/%s error: %v",
+			tag, t.destination.GetRegistry(), t.destination.GetRepository(), err)
+	}
+	data, err := mergeReferrersIndex(existing, copied)
+	if err != nil {
+		return t.Errorf("Merge referrers index %s of %s/%s error: %v",
+			tag, t.destination.GetRegistry(), t.destination.GetRepository(), err)
+	}
+	if data == nil {
+		return nil
+	}
+	if err := client.putManifest(ctx, tag, data, imgspecv1.MediaTypeImageIndex); err != nil {
+		return t.Errorf("Put referrers index %s to %s/%s error: %v",
+			tag, t.destination.GetRegistry(), t.destination.GetRepository(), err)
+	}
+	t.Infof("Put referrers index %s to %s/%s", tag, t.destination.GetRegistry(), t.destination.GetRepository())
+	return nil
+}
+
+// mergeReferrersIndex adds the descriptors which are not in the existing index, a new index is created if existing is nil.
+// It returns nil if all of them are in the index already.
+func mergeReferrersIndex(existing []byte, descriptors []imgspecv1.Descriptor) ([]byte, error) {
+	index := imgspecv1.Index{MediaType: imgspecv1.MediaTypeImageIndex}
+	index.SchemaVersion = 2
+	if existing != nil {
+		if err := json.Unmarshal(existing, &index); err != nil {
+			return nil, err
+		}
+	}
+	indexed := make(map[digest.Digest]bool, len(index.Manifests))
+	for _, d := range index.Manifests {
+		indexed[d.Digest] = true
+	}
+	changed := false
+	for _, d := range descriptors {
+		if !indexed[d.Digest] {
+			index.Manifests = append(index.Manifests, d)
+			indexed[d.Digest] = true
+			changed = true
+		}
+	}
+	if !changed {
+		return nil, nil
+	}
+	return json.Marshal(index)
+}
+
+// discoverReferrers finds the cosign artifacts and the OCI referrers of a subject,
+// the referrers index of the tag schema is used if the source registry does not support the referrers api
+func (t *Task) discoverReferrers(subject digest.Digest) ([]*referrer, error) {
+	client, ctx := t.source.client, t.source.ctx
+	tagPrefix := strings.Replace(subject.String(), ":", "-", 1)
+
+	var referrers []*referrer
+	for _, suffix := range cosignTagSuffixes {
+		r, err := t.getReferrer(tagPrefix + "." + suffix)
+		if errors.Is(err, errManifestNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		referrers = append(referrers, r)
+	}
+
+	descriptors, err := client.referrers(ctx, subject)
+	if errors.Is(err, errReferrersUnsupported) {
+		// the referrers tag schema of OCI 1.1
+		data, _, err := client.getManifest(ctx, tagPrefix)
+		if errors.Is(err, errManifestNotFound) {
+			return referrers, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		index, err := manifest.OCI1IndexFromManifest(data)
+		if err != nil {
+			return nil, err
+		}
+		descriptors = index.Manifests
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, d := range descriptors {
+		d := d
+		r, err := t.getReferrer(d.Digest.String())
+		if err != nil {
+			return nil, err
+		}
+		if d.ArtifactType != "" {
+			r.artifactType = d.ArtifactType
+		} else {
+			d.ArtifactType = r.artifactType
+		}
+		r.descriptor = &d
+		referrers = append(referrers, r)
+	}
+	return referrers, nil
+}
+
+func (t *Task) getReferrer(reference string) (*referrer, error) {
+	data, mediaType, err := t.source.client.getManifest(t.source.ctx, reference)
+	if err != nil {
+		return nil, err
+	}
+	var m artifactManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &referrer{reference: reference, artifactType: m.artifactType(), manifest: data, mediaType: mediaType}, nil
+}
+
+// copyReferrer copies the blobs and the manifest of a referrer, it returns false if the referrer is skipped
+func (t *Task) copyReferrer(r *referrer, statsMu *sync.Mutex) (bool, error) {
+	if manifest.MIMETypeIsMultiImage(r.mediaType) {
+		t.Infof("Skip referrer %s(%s) of %s/%s, manifest lists are not supported",
+			r.reference, r.artifactType, t.source.GetRegistry(), t.source.GetRepository())
+		return false, nil
+	}
+	var m artifactManifest
+	if err := json.Unmarshal(r.manifest, &m); err != nil {
+		return false, t.Errorf("Parse referrer %s of %s/%s error: %v", r.reference, t.source.GetRegistry(), t.source.GetRepository(), err)
+	}
+	for _, b := range m.blobInfos() {
+		if err := t.transferBlob(b, statsMu); err != nil {
+			return false, err
+		}
+	}
+	if err := t.destination.client.putManifest(t.destination.ctx, r.reference, r.manifest, r.mediaType); err != nil {
+		return false, t.Errorf("Put referrer %s(%s) to %s/%s error: %v",
+			r.reference, r.artifactType, t.destination.GetRegistry(), t.destination.GetRepository(), err)
+	}
+	t.stats.Referrers++
+	t.Infof("Put referrer %s(%s) to %s/%s", r.reference, r.artifactType, t.destination.GetRegistry(), t.destination.GetRepository())
+	return true, nil
+}
@@ -0,0 +1,151 @@
+package sync
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+// artifact returns an OCI image manifest of an artifact with a single layer
+func artifact(t *testing.T, r *fakeRegistry, configType string, layer []byte, layerType string, subject digest.Digest) []byte {
+	config := []byte("{}")
+	r.blobs[digest.FromBytes(config)] = config
+	r.blobs[digest.FromBytes(layer)] = layer
+	m := map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     imgspecv1.MediaTypeImageManifest,
+		"config":        imgspecv1.Descriptor{MediaType: configType, Digest: digest.FromBytes(config), Size: int64(len(config))},
+		"layers":        []imgspecv1.Descriptor{{MediaType: layerType, Digest: digest.FromBytes(layer), Size: int64(len(layer))}},
+	}
+	if subject != "" {
+		m["subject"] = imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageManifest, Digest: subject, Size: 100}
+	}
+	data, err := json.Marshal(m)
+	assert.NoError(t, err)
+	return data
+}
+
+func TestReferrersOptionsMatch(t *testing.T) {
+	options := &ReferrersOptions{}
+	assert.True(t, options.Match("application/spdx+json"))
+
+	options.ArtifactTypes = []string{"application/vnd.dev.cosign.*", "application/spdx+json"}
+	assert.True(t, options.Match("application/vnd.dev.cosign.simplesigning.v1+json"))
+	assert.True(t, options.Match("application/spdx+json"))
+	assert.False(t, options.Match("application/vnd.in-toto+json"))
+}
+
+func TestArtifactType(t *testing.T) {
+	m := artifactManifest{
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Config:    &imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageConfig},
+		Layers:    []imgspecv1.Descriptor{{MediaType: "application/vnd.dev.cosign.simplesigning.v1+json"}},
+	}
+	// cosign signatures have an image config, the type is the layer
+	assert.Equal(t, "application/vnd.dev.cosign.simplesigning.v1+json", m.artifactType())
+
+	m.Config.MediaType = "application/spdx+json"
+	assert.Equal(t, "application/spdx+json", m.artifactType())
+
+	m.ArtifactType = "application/vnd.example.sbom"
+	assert.Equal(t, "application/vnd.example.sbom", m.artifactType())
+}
+
+// referrersIndex returns the descriptors in the referrers index of the tag schema of dst
+func referrersIndex(t *testing.T, r *fakeRegistry, tag string) []imgspecv1.Descriptor {
+	var index imgspecv1.Index
+	assert.NoError(t, json.Unmarshal(r.manifests["dst/"+tag], &index))
+	return index.Manifests
+}
+
+func TestSyncReferrers(t *testing.T) {
+	r := newFakeRegistry(t)
+	subject := digest.FromString("image")
+	tagPrefix := strings.Replace(subject.String(), ":", "-", 1)
+
+	// a cosign signature and an SBOM attached by the referrers api
+	signature := artifact(t, r, imgspecv1.MediaTypeImageConfig, []byte("signature"), "application/vnd.dev.cosign.simplesigning.v1+json", "")
+	r.manifests["src/"+tagPrefix+".sig"] = signature
+	sbom := artifact(t, r, "application/spdx+json", []byte("sbom"), "application/spdx+json", subject)
+	r.manifests["src/"+digest.FromBytes(sbom).String()] = sbom
+	sbomDescriptor := imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageManifest, Digest: digest.FromBytes(sbom), Size: int64(len(sbom)), ArtifactType: "application/spdx+json"}
+	r.referrers = map[string][]imgspecv1.Descriptor{subject.String(): {sbomDescriptor}}
+
+	task := newFakeTask(r)
+	task.SetReferrers(&ReferrersOptions{ArtifactTypes: []string{"application/vnd.dev.cosign.*"}})
+	assert.NoError(t, task.syncReferrers([]digest.Digest{subject}))
+	assert.Equal(t, signature, r.manifests["dst/"+tagPrefix+".sig"])
+	assert.NotContains(t, r.manifests, "dst/"+digest.FromBytes(sbom).String(), "unselected artifact types are not synced")
+	assert.NotContains(t, r.manifests, "dst/"+tagPrefix, "cosign artifacts found by tag are not indexed")
+	assert.Equal(t, 1, task.stats.Referrers)
+
+	// the destination supports the referrers api, it indexes the referrers by their subject
+	r.destinationReferrers = true
+	task = newFakeTask(r)
+	task.SetReferrers(&ReferrersOptions{})
+	assert.NoError(t, task.syncReferrers([]digest.Digest{subject}))
+	assert.Equal(t, sbom, r.manifests["dst/"+digest.FromBytes(sbom).String()])
+	assert.Contains(t, r.committed, digest.FromBytes([]byte("sbom")))
+	assert.NotContains(t, r.manifests, "dst/"+tagPrefix)
+	assert.Equal(t, 2, task.stats.Referrers)
+
+	// the destination does not support the referrers api, the referrers index of the tag schema is pushed
+	r.destinationReferrers = false
+	task = newFakeTask(r)
+	task.SetReferrers(&ReferrersOptions{})
+	assert.NoError(t, task.syncReferrers([]digest.Digest{subject}))
+	assert.Equal(t, []imgspecv1.Descriptor{sbomDescriptor}, referrersIndex(t, r, tagPrefix))
+}
+
+func TestSyncReferrersTagSchema(t *testing.T) {
+	r := newFakeRegistry(t)
+	subject := digest.FromString("image")
+	tagPrefix := strings.Replace(subject.String(), ":", "-", 1)
+
+	// neither registry supports the referrers api, the referrers are listed by the index tag
+	sbom := artifact(t, r, "application/spdx+json", []byte("sbom"), "application/spdx+json", subject)
+	r.manifests["src/"+digest.FromBytes(sbom).String()] = sbom
+	attestation := artifact(t, r, "application/vnd.in-toto+json", []byte("attestation"), "application/vnd.in-toto+json", subject)
+	r.manifests["src/"+digest.FromBytes(attestation).String()] = attestation
+	sbomDescriptor := imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageManifest, Digest: digest.FromBytes(sbom), Size: int64(len(sbom)), ArtifactType: "application/spdx+json"}
+	attestationDescriptor := imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageManifest, Digest: digest.FromBytes(attestation), Size: int64(len(attestation)), ArtifactType: "application/vnd.in-toto+json"}
+	index, err := json.Marshal(imgspecv1.Index{
+		MediaType: imgspecv1.MediaTypeImageIndex,
+		Manifests: []imgspecv1.Descriptor{sbomDescriptor, attestationDescriptor},
+	})
+	assert.NoError(t, err)
+	r.manifests["src/"+tagPrefix] = index
+
+	// the index is rebuilt with the selected referrers only
+	task := newFakeTask(r)
+	task.SetReferrers(&ReferrersOptions{ArtifactTypes: []string{"application/spdx+json"}})
+	assert.NoError(t, task.syncReferrers([]digest.Digest{subject}))
+	assert.Equal(t, sbom, r.manifests["dst/"+digest.FromBytes(sbom).String()])
+	assert.NotContains(t, r.manifests, "dst/"+digest.FromBytes(attestation).String())
+	assert.Equal(t, []imgspecv1.Descriptor{sbomDescriptor}, referrersIndex(t, r, tagPrefix), "referrers which are not copied are not indexed")
+	assert.Equal(t, 1, task.stats.Referrers)
+
+	// the referrers synced later are merged into the index of destination
+	task = newFakeTask(r)
+	task.SetReferrers(&ReferrersOptions{})
+	assert.NoError(t, task.syncReferrers([]digest.Digest{subject}))
+	assert.Equal(t, []imgspecv1.Descriptor{sbomDescriptor, attestationDescriptor}, referrersIndex(t, r, tagPrefix))
+
+	// the index is not pushed if none of the referrers is copied
+	delete(r.manifests, "dst/"+tagPrefix)
+	task = newFakeTask(r)
+	task.SetReferrers(&ReferrersOptions{ArtifactTypes: []string{"application/vnd.dev.cosign.*"}})
+	assert.NoError(t, task.syncReferrers([]digest.Digest{subject}))
+	assert.NotContains(t, r.manifests, "dst/"+tagPrefix)
+	assert.Equal(t, 0, task.stats.Referrers)
+
+	// referrers are not synced by default
+	task = newFakeTask(r)
+	r.manifests = map[string][]byte{}
+	assert.NoError(t, task.syncReferrers([]digest.Digest{subject}))
+	assert.Empty(t, r.manifests)
+}
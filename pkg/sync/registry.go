@@ -0,0 +1,408 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var (
+	errManifestNotFound     = errors.New("manifest not found")
+	errReferrersUnsupported = errors.New("referrers api is not supported")
+)
+
+// maxManifestSize limits the manifests read into memory
+const maxManifestSize = 4 << 20
+
+// artifactManifestMediaType is the manifest of OCI artifacts in image-spec v1.1.0-rc1
+const artifactManifestMediaType = "application/vnd.oci.artifact.manifest.v1+json"
+
+// manifestAccept is the Accept header of manifest requests
+var manifestAccept = strings.Join(manifest.DefaultRequestedManifestMIMETypes, ", ") + ", " + artifactManifestMediaType
+
+// registryClient calls the registry http api v2 directly for what containers/image does not expose,
+// such as range requests of blobs and chunked upload sessions
+type registryClient struct {
+	baseURL    string
+	host       string
+	repository string
+	username   string
+	password   string
+	// actions of the token scope, "pull" or "pull,push"
+	actions string
+	client  *http.Client
+	// an insecure registry skips the tls verification and falls back to plain http
+	insecure bool
+
+	mu sync.Mutex
+	// value of the Authorization header, empty if the registry allows anonymous access
+	authorization string
+	authorized    bool
+	// parameters of the bearer challenge, nil if the registry does not use token auth
+	challenge map[string]string
+	// the insecure registry does not serve https
+	plainHTTP bool
+}
+
+func newRegistryClient(registry, repository string, sysctx *types.SystemContext, actions string) *registryClient {
+	host := registry
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	insecure := sysctx != nil && sysctx.DockerInsecureSkipTLSVerify == types.OptionalBoolTrue
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	c := &registryClient{
+		baseURL:    "https://" + host,
+		host:       host,
+		repository: repository,
+		actions:    actions,
+		client:     &http.Client{Transport: transport},
+		insecure:   insecure,
+	}
+	if sysctx != nil && sysctx.DockerAuthConfig != nil {
+		c.username, c.password = sysctx.DockerAuthConfig.Username, sysctx.DockerAuthConfig.Password
+	}
+	return c
+}
+
+func (c *registryClient) blobURL(dgst digest.Digest) string {
+	return c.baseURL + "/v2/" + c.repository + "/blobs/" + dgst.String()
+}
+
+func (c *registryClient) uploadURL() string {
+	return c.baseURL + "/v2/" + c.repository + "/blobs/uploads/"
+}
+
+// location resolves the Location header of a response, it may be relative to the registry
+func (c *registryClient) location(resp *http.Response) (string, error) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("%s %s: no Location in response", resp.Request.Method, resp.Request.URL.Path)
+	}
+	base, err := url.Parse(c.baseURL)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// getManifest gets a manifest by tag or digest, errManifestNotFound is returned if it does not exist
+func (c *registryClient) getManifest(ctx context.Context, reference string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/v2/"+c.repository+"/manifests/"+reference, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", manifestAccept)
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", errManifestNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", responseError(resp, "get manifest "+reference)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return nil, "", err
+	}
+	mediaType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	if mediaType == "" || mediaType == "application/json" || mediaType == "text/plain" {
+		mediaType = manifest.GuessMIMEType(data)
+	}
+	return data, mediaType, nil
+}
+
+// putManifest pushes a manifest by tag or digest
+func (c *registryClient) putManifest(ctx context.Context, reference string, data []byte, mediaType string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.baseURL+"/v2/"+c.repository+"/manifests/"+reference, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mediaType)
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(resp, "put manifest "+reference)
+	}
+	return nil
+}
+
+// blobSize checks if a blob exists by a HEAD request, the size is -1 if the blob does not exist
+func (c *registryClient) blobSize(ctx context.Context, dgst digest.Digest) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.blobURL(dgst), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.ContentLength, nil
+	case http.StatusNotFound:
+		return -1, nil
+	default:
+		return 0, responseError(resp, "head blob "+dgst.String())
+	}
+}
+
+// referrers lists the manifests whose subject is the digest by the OCI 1.1 referrers api,
+// errReferrersUnsupported is returned if the registry does not support it
+func (c *registryClient) referrers(ctx context.Context, subject digest.Digest) ([]imgspecv1.Descriptor, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/v2/"+c.repository+"/referrers/"+subject.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", imgspecv1.MediaTypeImageIndex)
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusBadRequest:
+		return nil, errReferrersUnsupported
+	default:
+		return nil, responseError(resp, "get referrers of "+subject.String())
+	}
+	var index imgspecv1.Index
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&index); err != nil {
+		return nil, fmt.Errorf("decode referrers of %s error: %v", subject, err)
+	}
+	return index.Manifests, nil
+}
+
+// authorize gets the Authorization header by the challenge of the registry, the result is cached until it is unauthorized
+func (c *registryClient) authorize(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.authorized {
+		return c.authorization, nil
+	}
+
+	scheme := "https"
+	if c.plainHTTP {
+		scheme = "http"
+	}
+	resp, err := c.ping(ctx, scheme)
+	if err != nil && c.insecure && !c.plainHTTP {
+		// an insecure registry may only serve plain http, as containers/image does
+		var httpErr error
+		if resp, httpErr = c.ping(ctx, "http"); httpErr == nil {
+			c.plainHTTP, err = true, nil
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	authorization := ""
+	var challenge map[string]string
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		scheme, params := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+		switch strings.ToLower(scheme) {
+		case "basic":
+			authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(c.username+":"+c.password))
+		case "bearer":
+			token, err := c.fetchToken(ctx, params)
+			if err != nil {
+				return "", err
+			}
+			authorization, challenge = "Bearer "+token, params
+		default:
+			return "", fmt.Errorf("unsupported auth scheme %q of %s", scheme, c.baseURL)
+		}
+	case resp.StatusCode >= 300:
+		return "", fmt.Errorf("ping %s: %s", c.baseURL, resp.Status)
+	}
+	c.authorization, c.authorized, c.challenge = authorization, true, challenge
+	return authorization, nil
+}
+
+// ping requests /v2/ of the registry by the scheme
+func (c *registryClient) ping(ctx context.Context, scheme string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+"://"+c.host+"/v2/", nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.client.Do(req)
+}
+
+// resolveScheme sends a request of the registry by plain http if the registry does not serve https
+func (c *registryClient) resolveScheme(req *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.plainHTTP && req.URL.Scheme == "https" && req.URL.Host == c.host {
+		req.URL.Scheme = "http"
+	}
+}
+
+// mountAuthorization gets the Authorization header of mounting a blob from another repository,
+// a bearer token needs the pull scope of that repository too
+func (c *registryClient) mountAuthorization(ctx context.Context, from string) (string, error) {
+	authorization, err := c.authorize(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	challenge := c.challenge
+	c.mu.Unlock()
+	if challenge == nil {
+		return authorization, nil
+	}
+	token, err := c.fetchToken(ctx, challenge, "repository:"+from+":pull")
+	if err != nil {
+		return "", err
+	}
+	return "Bearer " + token, nil
+}
+
+// fetchToken gets a bearer token of the repository and the extra scopes from the token service
+func (c *registryClient) fetchToken(ctx context.Context, params map[string]string, scopes ...string) (string, error) {
+	if params["realm"] == "" {
+		return "", fmt.Errorf("no realm in the bearer challenge of %s", c.baseURL)
+	}
+	u, err := url.Parse(params["realm"])
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	query.Set("scope", "repository:"+c.repository+":"+c.actions)
+	for _, scope := range scopes {
+		query.Add("scope", scope)
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp, "get token")
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decode token of %s error: %v", c.baseURL, err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", fmt.Errorf("empty token of %s", c.baseURL)
+}
+
+// do sends a request with authorization, it is retried once with a new token if it is unauthorized
+func (c *registryClient) do(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		authorization, err := c.authorize(req.Context())
+		if err != nil {
+			return nil, err
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		c.resolveScheme(req)
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 || (req.Body != nil && req.GetBody == nil) {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		// the token may be expired
+		c.mu.Lock()
+		c.authorized = false
+		c.mu.Unlock()
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// parseChallenge parses a WWW-Authenticate header like `Bearer realm="...",service="...",scope="..."`
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := make(map[string]string)
+	rest = strings.TrimSpace(rest)
+	for rest != "" {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if strings.HasPrefix(value, `"`) {
+			// quoted values may contain commas
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				params[key] = value[1:]
+				break
+			}
+			params[key] = value[1 : end+1]
+			rest = value[end+2:]
+		} else {
+			params[key], rest, _ = strings.Cut(value, ",")
+			params[key] = strings.TrimSpace(params[key])
+		}
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return scheme, params
+}
+
+// responseError describes an unexpected response with the beginning of its body
+func responseError(resp *http.Response, action string) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s: %s %s", action, resp.Status, strings.TrimSpace(string(body)))
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
 
 	"aliyun-images-syncer/pkg/tools"
 
@@ -18,6 +19,8 @@ type ImageSource struct {
 	source    types.ImageSource
 	ctx       context.Context
 	sysctx    *types.SystemContext
+	// used for range requests of blobs
+	client *registryClient
 
 	// source image description
 	registry   string
@@ -74,6 +77,7 @@ func NewImageSource(registry, repository, tag, username, password string, insecu
 		source:     rawSource,
 		ctx:        ctx,
 		sysctx:     sysctx,
+		client:     newRegistryClient(registry, repository, sysctx, "pull"),
 		registry:   registry,
 		repository: repository,
 		tag:        tag,
@@ -115,6 +119,45 @@ func (i *ImageSource) GetABlob(blobInfo types.BlobInfo) (io.ReadCloser, int64, e
 	return i.source.GetBlob(i.ctx, types.BlobInfo{Digest: blobInfo.Digest, URLs: blobInfo.URLs, Size: -1}, NoCache)
 }
 
+// GetABlobFrom gets a blob from remote image starting at offset,
+// the first offset bytes are read and discarded if the registry does not support range requests
+func (i *ImageSource) GetABlobFrom(blobInfo types.BlobInfo, offset int64) (io.ReadCloser, error) {
+	if offset == 0 || len(blobInfo.URLs) != 0 {
+		blob, _, err := i.GetABlob(blobInfo)
+		if err != nil || offset == 0 {
+			return blob, err
+		}
+		return skipBlob(blob, offset)
+	}
+
+	req, err := http.NewRequestWithContext(i.ctx, http.MethodGet, i.client.blobURL(blobInfo.Digest), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	resp, err := i.client.do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		return skipBlob(resp.Body, offset)
+	default:
+		defer resp.Body.Close()
+		return nil, responseError(resp, "get blob")
+	}
+}
+
+func skipBlob(blob io.ReadCloser, offset int64) (io.ReadCloser, error) {
+	if _, err := io.CopyN(io.Discard, blob, offset); err != nil {
+		blob.Close()
+		return nil, err
+	}
+	return blob, nil
+}
+
 // Close an ImageSource
 func (i *ImageSource) Close() error {
 	return i.source.Close()
@@ -1,12 +1,21 @@
 package sync
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
 
 	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/opencontainers/go-digest"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
 )
 
 var (
@@ -22,9 +31,70 @@ type Task struct {
 	osFilterList   []string
 	archFilterList []string
 
+	// every run fails after timeout, 0 means no timeout
+	timeout time.Duration
+	// every run is cancelled when ctx is done, nil means never
+	ctx context.Context
+
+	// blobs are uploaded in chunks of chunkSize
+	chunkSize int
+	// bytes per second pulled from source, 0 means no limit
+	bandwidth int
+	limiter   *rate.Limiter
+	progress  func(BlobProgress)
+	// upload sessions interrupted by the last run
+	uploads   map[digest.Digest]*uploadSession
+	uploadsMu sync.Mutex
+
+	// number of blobs transferred at the same time
+	blobWorkers int
+	// deduplicates blob transfers, shared by the tasks of the same round
+	blobs *BlobGroup
+
+	// artifacts attached to the synced manifests, nil means they are not synced
+	referrers *ReferrersOptions
+	// artifacts synced like images, nil means all
+	artifacts *ArtifactOptions
+	// check the manifests and blobs of destination after every run
+	verify bool
+
+	// statistics of the last run
+	stats TaskStats
+
 	logger *logrus.Logger
 }
 
+// TaskStats describes what the last run of a sync task did
+type TaskStats struct {
+	// digest of the source manifest and the manifest pushed to destination,
+	// they are different if the manifest list is filtered by os or architecture
+	SourceDigest      string
+	DestinationDigest string
+
+	// number of manifests selected by os and architecture
+	Manifests int
+
+	// blobs and bytes transferred, or to be transferred if DryRun is set
+	BlobsTransferred int
+	// blobs already exist in destination
+	BlobsSkipped     int
+	BytesTransferred int64
+
+	// signatures, SBOMs and attestations copied with the image
+	Referrers int
+	// the artifact type if the source is not a container image
+	ArtifactType string
+	// platforms removed from the manifest list by os or architecture, the destination digest differs from the source
+	FilteredPlatforms []string
+	// the manifests and blobs of destination are checked after the run
+	DestinationVerified bool
+
+	// the stats is a plan, nothing is pushed to destination
+	DryRun bool
+
+	Duration time.Duration
+}
+
 // NewTask creates a sync task
 func NewTask(source *ImageSource, destination *ImageDestination,
 	osFilterList, archFilterList []string, logger *logrus.Logger) *Task {
@@ -39,23 +109,116 @@ func NewTask(source *ImageSource, destination *ImageDestination,
 
 		osFilterList:   osFilterList,
 		archFilterList: archFilterList,
+
+		chunkSize:   DefaultChunkSize,
+		uploads:     make(map[digest.Digest]*uploadSession),
+		blobWorkers: 1,
+		blobs:       NewBlobGroup(),
 	}
 }
 
-// Run is the main function of a sync task
-func (t *Task) Run() error {
+// SetBlobWorkers sets the number of blobs transferred at the same time, less than 1 means one by one
+func (t *Task) SetBlobWorkers(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	t.blobWorkers = workers
+}
+
+// SetBlobGroup shares the blob transfers with other tasks, a blob needed by several tasks is transferred once
+func (t *Task) SetBlobGroup(blobs *BlobGroup) {
+	if blobs != nil {
+		t.blobs = blobs
+	}
+}
+
+// SetTimeout limits the duration of every run, 0 means no timeout
+func (t *Task) SetTimeout(timeout time.Duration) {
+	t.timeout = timeout
+}
+
+// SetContext cancels the runs when ctx is done, such as when the replica loses the leadership
+func (t *Task) SetContext(ctx context.Context) {
+	t.ctx = ctx
+}
+
+// withContext makes the requests to source and destination fail after timeout or when the context of the task is done,
+// call the returned func after run
+func (t *Task) withContext() func() {
+	if t.timeout <= 0 && t.ctx == nil {
+		return func() {}
+	}
+	sourceCtx, destinationCtx := t.source.ctx, t.destination.ctx
+	var cancelSource, cancelDestination context.CancelFunc
+	if t.timeout > 0 {
+		deadline := time.Now().Add(t.timeout)
+		t.source.ctx, cancelSource = context.WithDeadline(sourceCtx, deadline)
+		t.destination.ctx, cancelDestination = context.WithDeadline(destinationCtx, deadline)
+	} else {
+		t.source.ctx, cancelSource = context.WithCancel(sourceCtx)
+		t.destination.ctx, cancelDestination = context.WithCancel(destinationCtx)
+	}
+	stop := make(chan struct{})
+	if t.ctx != nil {
+		// the source and destination contexts carry values, they are cancelled instead of derived from t.ctx
+		go func(done <-chan struct{}) {
+			select {
+			case <-done:
+				cancelSource()
+				cancelDestination()
+			case <-stop:
+			}
+		}(t.ctx.Done())
+	}
+	return func() {
+		close(stop)
+		cancelSource()
+		cancelDestination()
+		t.source.ctx, t.destination.ctx = sourceCtx, destinationCtx
+	}
+}
+
+// resolvedManifest is the source manifest with os and architecture filtered
+type resolvedManifest struct {
+	bytes     []byte
+	mediaType string
+	// manifests selected by os and architecture
+	infos []manifest.Manifest
+	// the filtered manifest list, nil if nothing is filtered
+	filtered  interface{}
+	blobInfos []types.BlobInfo
+	// the artifact type if it is not a container image, the manifest is copied verbatim
+	artifactType string
+}
+
+// resolve gets the source manifest and blob infos,
+// it returns nil if no manifest matches the os or architecture or the artifact type is not selected
+func (t *Task) resolve() (*resolvedManifest, error) {
 	// get manifest from source
 	manifestBytes, manifestType, err := t.source.GetManifest()
 	if err != nil {
-		return t.Errorf("Failed to get manifest from %s/%s:%s error: %v",
+		return nil, t.Errorf("Failed to get manifest from %s/%s:%s error: %v",
 			t.source.GetRegistry(), t.source.GetRepository(), t.source.GetTag(), err)
 	}
 	t.Infof("Get manifest from %s/%s:%s", t.source.GetRegistry(), t.source.GetRepository(), t.source.GetTag())
+	if sourceDigest, err := manifest.Digest(manifestBytes); err == nil {
+		t.stats.SourceDigest = sourceDigest.String()
+	}
+
+	// artifacts such as Helm charts are copied without os and architecture
+	artifactType, err := manifestArtifactType(manifestBytes, manifestType)
+	if err != nil {
+		return nil, t.Errorf("Parse manifest from %s/%s:%s error: %v",
+			t.source.GetRegistry(), t.source.GetRepository(), t.source.GetTag(), err)
+	}
+	if artifactType != "" {
+		return t.resolveArtifact(manifestBytes, manifestType, artifactType)
+	}
 
 	manifestInfoSlice, thisManifestInfo, err := ManifestHandler(manifestBytes, manifestType,
 		t.osFilterList, t.archFilterList, t.source, nil)
 	if err != nil {
-		return t.Errorf("Get manifest info from %s/%s:%s error: %v",
+		return nil, t.Errorf("Get manifest info from %s/%s:%s error: %v",
 			t.source.GetRegistry(), t.source.GetRepository(), t.source.GetTag(), err)
 	}
 
@@ -63,57 +226,121 @@ func (t *Task) Run() error {
 		t.Infof("Skip synchronization from %s/%s:%s to %s/%s:%s, mismatch of os or architecture",
 			t.source.GetRegistry(), t.source.GetRepository(), t.source.GetTag(),
 			t.destination.GetRegistry(), t.destination.GetRepository(), t.destination.GetTag())
-		return nil
+		return nil, nil
+	}
+	t.stats.Manifests = len(manifestInfoSlice)
+	if filtered, ok := thisManifestInfo.(manifest.List); ok {
+		if original, err := manifest.ListFromBlob(manifestBytes, manifestType); err == nil {
+			t.stats.FilteredPlatforms = removedPlatforms(original, filtered)
+		}
 	}
 
 	blobInfos, err := t.source.GetBlobInfos(manifestInfoSlice)
 	if err != nil {
-		return t.Errorf("Get blob info from %s/%s:%s error: %v",
+		return nil, t.Errorf("Get blob info from %s/%s:%s error: %v",
 			t.source.GetRegistry(), t.source.GetRepository(), t.source.GetTag(), err)
 	}
 
-	// blob transformation
-	for _, b := range blobInfos {
+	return &resolvedManifest{
+		bytes:     manifestBytes,
+		mediaType: manifestType,
+		infos:     manifestInfoSlice,
+		filtered:  thisManifestInfo,
+		blobInfos: blobInfos,
+	}, nil
+}
+
+// Plan resolves the source manifest and checks which blobs already exist in destination,
+// the blobs and bytes to transfer are recorded in stats, nothing is pulled or pushed
+func (t *Task) Plan() error {
+	t.stats = TaskStats{DryRun: true}
+	start := time.Now()
+	defer func() {
+		t.stats.Duration = time.Since(start)
+	}()
+	defer t.withContext()()
+
+	resolved, err := t.resolve()
+	if err != nil || resolved == nil {
+		return err
+	}
+	for _, b := range resolved.blobInfos {
 		blobExist, err := t.destination.CheckBlobExist(b)
 		if err != nil {
 			return t.Errorf("Check blob %s(%v) to %s/%s:%s exist error: %v",
 				b.Digest, b.Size, t.destination.GetRegistry(), t.destination.GetRepository(), t.destination.GetTag(), err)
 		}
+		if blobExist {
+			t.stats.BlobsSkipped++
+			continue
+		}
+		t.stats.BlobsTransferred++
+		// size is -1 if the manifest does not record it
+		if b.Size > 0 {
+			t.stats.BytesTransferred += b.Size
+		}
+	}
+	t.Infof("Plan synchronization from %s/%s:%s to %s/%s:%s, %d blobs (%d bytes) to transfer, %d blobs exist",
+		t.source.GetRegistry(), t.source.GetRepository(), t.source.GetTag(),
+		t.destination.GetRegistry(), t.destination.GetRepository(), t.destination.GetTag(),
+		t.stats.BlobsTransferred, t.stats.BytesTransferred, t.stats.BlobsSkipped)
+	return nil
+}
 
-		if !blobExist {
-			// pull a blob from source
-			blob, size, err := t.source.GetABlob(b)
+// Run is the main function of a sync task
+func (t *Task) Run() error {
+	t.stats = TaskStats{}
+	start := time.Now()
+	defer func() {
+		t.stats.Duration = time.Since(start)
+	}()
+	defer t.withContext()()
+	t.limiter = t.newLimiter()
+
+	resolved, err := t.resolve()
+	if err != nil || resolved == nil {
+		return err
+	}
+	manifestBytes, manifestType := resolved.bytes, resolved.mediaType
+	manifestInfoSlice, thisManifestInfo, blobInfos := resolved.infos, resolved.filtered, resolved.blobInfos
+
+	// blob transformation, blobWorkers blobs at the same time
+	var (
+		blobs   errgroup.Group
+		statsMu sync.Mutex
+		failed  atomic.Bool
+	)
+	blobs.SetLimit(t.blobWorkers)
+	for _, b := range blobInfos {
+		// stop starting new blobs after the first failure
+		if failed.Load() {
+			break
+		}
+		b := b
+		blobs.Go(func() error {
+			err := t.transferBlob(b, &statsMu)
 			if err != nil {
-				return t.Errorf("Get blob %s(%v) from %s/%s:%s failed: %v",
-					b.Digest, size, t.source.GetRegistry(), t.source.GetRepository(), t.source.GetTag(), err)
-			}
-			t.Infof("Get a blob %s(%v) from %s/%s:%s success",
-				b.Digest, size, t.source.GetRegistry(), t.source.GetRepository(), t.source.GetTag())
-
-			b.Size = size
-			// push a blob to destination
-			if err := t.destination.PutABlob(blob, b); err != nil {
-				return t.Errorf("Put blob %s(%v) to %s/%s:%s failed: %v",
-					b.Digest, b.Size, t.destination.GetRegistry(), t.destination.GetRepository(), t.destination.GetTag(), err)
+				failed.Store(true)
 			}
-			t.Infof("Put blob %s(%v) to %s/%s:%s success",
-				b.Digest, b.Size, t.destination.GetRegistry(), t.destination.GetRepository(), t.destination.GetTag())
-		} else {
-			// print the log of ignored blob
-			t.Infof("Blob %s(%v) has been pushed to %s, will not be pushed",
-				b.Digest, b.Size, t.destination.GetRegistry()+"/"+t.destination.GetRepository())
-		}
-
+			return err
+		})
+	}
+	if err := blobs.Wait(); err != nil {
+		return err
 	}
 
-	// Push manifest list
-	if manifestType == manifest.DockerV2ListMediaType {
-		var manifestSchemaListInfo *manifest.Schema2List
+	if resolved.artifactType != "" {
+		if err := t.pushArtifact(resolved); err != nil {
+			return err
+		}
+	} else if manifest.MIMETypeIsMultiImage(manifestType) {
+		// Push manifest list or image index
+		var manifestList manifest.List
 		if thisManifestInfo == nil {
-			manifestSchemaListInfo, err = manifest.Schema2ListFromManifest(manifestBytes)
+			manifestList, err = manifest.ListFromBlob(manifestBytes, manifestType)
 		} else {
-			manifestSchemaListInfo = thisManifestInfo.(*manifest.Schema2List)
-			manifestBytes, err = manifestSchemaListInfo.Serialize()
+			manifestList = thisManifestInfo.(manifest.List)
+			manifestBytes, err = manifestList.Serialize()
 		}
 
 		if err != nil {
@@ -123,15 +350,15 @@ func (t *Task) Run() error {
 		var subManifestByte []byte
 
 		// push manifest to destination
-		for _, manifestDescriptorElem := range manifestSchemaListInfo.Manifests {
-			t.Infof("handle manifest OS:%s Architecture:%s ",
-				manifestDescriptorElem.Platform.OS, manifestDescriptorElem.Platform.Architecture)
+		digests, platforms := listPlatforms(manifestList)
+		for index, manifestDigest := range digests {
+			platform := platforms[index]
+			t.Infof("handle manifest OS:%s Architecture:%s ", platform.OS, platform.Architecture)
 
-			subManifestByte, _, err = t.source.source.GetManifest(t.source.ctx, &manifestDescriptorElem.Digest)
+			subManifestByte, _, err = t.source.source.GetManifest(t.source.ctx, &manifestDigest)
 			if err != nil {
 				return t.Errorf("Get manifest %v of OS:%s Architecture:%s for manifest list error: %v",
-					manifestDescriptorElem.Digest, manifestDescriptorElem.Platform.OS,
-					manifestDescriptorElem.Platform.Architecture, err)
+					manifestDigest, platform.OS, platform.Architecture, err)
 			}
 
 			if err := t.destination.PushManifest(subManifestByte); err != nil {
@@ -141,7 +368,7 @@ func (t *Task) Run() error {
 
 			t.Infof("Put manifest to %s/%s:%s os:%s arch:%s",
 				t.destination.GetRegistry(), t.destination.GetRepository(), t.destination.GetTag(),
-				manifestDescriptorElem.Platform.OS, manifestDescriptorElem.Platform.Architecture)
+				platform.OS, platform.Architecture)
 		}
 
 		// push manifest list to destination
@@ -150,9 +377,15 @@ func (t *Task) Run() error {
 				return t.Errorf("Put manifestList to %s/%s:%s error: %v",
 					t.destination.GetRegistry(), t.destination.GetRepository(), t.destination.GetTag(), err)
 			}
+			t.setDestinationDigest(manifestBytes)
 
 			t.Infof("Put manifestList to %s/%s:%s",
 				t.destination.GetRegistry(), t.destination.GetRepository(), t.destination.GetTag())
+			if len(t.stats.FilteredPlatforms) != 0 {
+				t.Infof("Manifest list %s/%s:%s is filtered by os or architecture, digest changes from %s to %s, removed platforms: %s",
+					t.source.GetRegistry(), t.source.GetRepository(), t.source.GetTag(),
+					t.stats.SourceDigest, t.stats.DestinationDigest, strings.Join(t.stats.FilteredPlatforms, ", "))
+			}
 		}
 	} else if len(manifestInfoSlice) != 0 {
 		// push manifest to destination
@@ -160,11 +393,24 @@ func (t *Task) Run() error {
 			return t.Errorf("Put manifest to %s/%s:%s error: %v",
 				t.destination.GetRegistry(), t.destination.GetRepository(), t.destination.GetTag(), err)
 		}
+		t.setDestinationDigest(manifestBytes)
 
 		t.Infof("Put manifest to %s/%s:%s", t.destination.GetRegistry(),
 			t.destination.GetRepository(), t.destination.GetTag())
 	}
 
+	// sync the signatures, SBOMs and attestations of the pushed manifests
+	if err := t.syncReferrers(t.referrerSubjects(resolved)); err != nil {
+		return err
+	}
+
+	// make sure destination serves what is pushed
+	if t.verify && t.stats.DestinationDigest != "" {
+		if err := t.verifyDestination(resolved); err != nil {
+			return err
+		}
+	}
+
 	t.Infof("Synchronization successfully from %s/%s:%s to %s/%s:%s",
 		t.source.GetRegistry(), t.source.GetRepository(), t.source.GetTag(),
 		t.destination.GetRegistry(), t.destination.GetRepository(), t.destination.GetTag())
@@ -172,6 +418,90 @@ func (t *Task) Run() error {
 	return nil
 }
 
+// transferBlob copies a blob unless it exists in destination or is transferred by another task of the same round
+func (t *Task) transferBlob(b types.BlobInfo, statsMu *sync.Mutex) error {
+	blobExist, err := t.destination.CheckBlobExist(b)
+	if err != nil {
+		return t.Errorf("Check blob %s(%v) to %s/%s:%s exist error: %v",
+			b.Digest, b.Size, t.destination.GetRegistry(), t.destination.GetRepository(), t.destination.GetTag(), err)
+	}
+	if blobExist {
+		statsMu.Lock()
+		t.stats.BlobsSkipped++
+		statsMu.Unlock()
+		// print the log of ignored blob
+		t.Infof("Blob %s(%v) has been pushed to %s, will not be pushed",
+			b.Digest, b.Size, t.destination.GetRegistry()+"/"+t.destination.GetRepository())
+		return nil
+	}
+
+	var transferred, size int64
+	// a blob is transferred to a registry once, the other repositories mount it
+	key := t.destination.GetRegistry() + "@" + b.Digest.String()
+	owner, from, err := t.blobs.Do(t.destination.ctx, key, t.destination.GetRepository(), func() error {
+		var err error
+		// stream a blob from source to destination, an interrupted upload is resumed by the next run
+		transferred, size, err = t.pushBlob(b)
+		return err
+	})
+	if err != nil && !owner {
+		return t.Errorf("Wait for blob %s(%v) to %s/%s:%s error: %v",
+			b.Digest, b.Size, t.destination.GetRegistry(), t.destination.GetRepository(), t.destination.GetTag(), err)
+	}
+	if !owner && from != t.destination.GetRepository() {
+		if err = t.destination.mountBlob(b.Digest, from); err == nil {
+			statsMu.Lock()
+			t.stats.BlobsSkipped++
+			statsMu.Unlock()
+			t.Infof("Blob %s(%v) has been mounted from %s/%s to %s/%s",
+				b.Digest, b.Size, t.destination.GetRegistry(), from, t.destination.GetRegistry(), t.destination.GetRepository())
+			return nil
+		}
+		t.Infof("Cannot mount blob %s(%v) from %s/%s to %s/%s, will be pushed: %v",
+			b.Digest, b.Size, t.destination.GetRegistry(), from, t.destination.GetRegistry(), t.destination.GetRepository(), err)
+		owner = true
+		transferred, size, err = t.pushBlob(b)
+	}
+
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	t.stats.BytesTransferred += transferred
+	if err != nil {
+		return err
+	}
+	if !owner {
+		t.stats.BlobsSkipped++
+		t.Infof("Blob %s(%v) has been pushed to %s by another task, will not be pushed",
+			b.Digest, b.Size, t.destination.GetRegistry()+"/"+t.destination.GetRepository())
+		return nil
+	}
+	t.stats.BlobsTransferred++
+	t.Infof("Put blob %s(%v) to %s/%s:%s success",
+		b.Digest, size, t.destination.GetRegistry(), t.destination.GetRepository(), t.destination.GetTag())
+	return nil
+}
+
+func (t *Task) setDestinationDigest(manifestBytes []byte) {
+	if destinationDigest, err := manifest.Digest(manifestBytes); err == nil {
+		t.stats.DestinationDigest = destinationDigest.String()
+	}
+}
+
+// Stats returns the statistics of the last run
+func (t *Task) Stats() TaskStats {
+	return t.stats
+}
+
+// GetSource returns the source of a sync task
+func (t *Task) GetSource() *ImageSource {
+	return t.source
+}
+
+// GetDestination returns the destination of a sync task
+func (t *Task) GetDestination() *ImageDestination {
+	return t.destination
+}
+
 // Errorf logs error to logger
 func (t *Task) Errorf(format string, args ...interface{}) error {
 	t.logger.Errorf(format, args...)
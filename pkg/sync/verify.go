@@ -0,0 +1,73 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/containers/image/v5/manifest"
+)
+
+// SetVerify checks the destination after every run, the run fails if the destination does not serve the same content
+func (t *Task) SetVerify(verify bool) {
+	t.verify = verify
+}
+
+// verifyDestination fetches the pushed manifest from destination and compares its digest with the pushed one,
+// every manifest of a list is fetched by digest, and every blob is checked by a HEAD request
+func (t *Task) verifyDestination(resolved *resolvedManifest) error {
+	if err := t.verifyManifests(); err != nil {
+		return t.Errorf("Verify manifest of %s/%s:%s error: %v",
+			t.destination.GetRegistry(), t.destination.GetRepository(), t.destination.GetTag(), err)
+	}
+	for _, b := range resolved.blobInfos {
+		size, err := t.destination.client.blobSize(t.destination.ctx, b.Digest)
+		if err == nil && size < 0 {
+			err = errors.New("blob not found")
+		} else if err == nil && b.Size > 0 && size != b.Size {
+			err = fmt.Errorf("size %d, expected %d", size, b.Size)
+		}
+		if err != nil {
+			return t.Errorf("Verify blob %s(%v) of %s/%s:%s error: %v", b.Digest, b.Size,
+				t.destination.GetRegistry(), t.destination.GetRepository(), t.destination.GetTag(), err)
+		}
+	}
+	t.stats.DestinationVerified = true
+	t.Infof("Verify %s/%s:%s success, %d blobs exist",
+		t.destination.GetRegistry(), t.destination.GetRepository(), t.destination.GetTag(), len(resolved.blobInfos))
+	return nil
+}
+
+// verifyManifests compares the destination manifest of the tag and the manifests of a list with the pushed digests
+func (t *Task) verifyManifests() error {
+	client, ctx := t.destination.client, t.destination.ctx
+	data, mediaType, err := client.getManifest(ctx, t.destination.GetTag())
+	if err != nil {
+		return err
+	}
+	dgst, err := manifest.Digest(data)
+	if err != nil {
+		return err
+	}
+	if dgst.String() != t.stats.DestinationDigest {
+		return fmt.Errorf("digest %s, expected %s", dgst, t.stats.DestinationDigest)
+	}
+	if !manifest.MIMETypeIsMultiImage(mediaType) {
+		return nil
+	}
+
+	list, err := manifest.ListFromBlob(data, mediaType)
+	if err != nil {
+		return err
+	}
+	digests, platforms := listPlatforms(list)
+	for index, expected := range digests {
+		data, _, err := client.getManifest(ctx, expected.String())
+		if err != nil {
+			return fmt.Errorf("manifest %s of %s: %v", expected, platformString(platforms[index]), err)
+		}
+		if actual := expected.Algorithm().FromBytes(data); actual != expected {
+			return fmt.Errorf("manifest of %s: digest %s, expected %s", platformString(platforms[index]), actual, expected)
+		}
+	}
+	return nil
+}
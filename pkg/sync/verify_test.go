@@ -0,0 +1,52 @@
+package sync
+
+import (
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyDestination(t *testing.T) {
+	r := newFakeRegistry(t)
+	chart := artifact(t, r, helmConfigMediaType, []byte("chart"), "application/vnd.cncf.helm.chart.content.v1.tar+gzip", "")
+
+	task := newFakeTask(r)
+	task.source.source.(*fakeImageSource).manifest = chart
+	task.destination.tag = "1.0.0"
+	task.SetVerify(true)
+	assert.NoError(t, task.Run())
+	assert.True(t, task.Stats().DestinationVerified)
+
+	resolved, err := task.resolve()
+	assert.NoError(t, err)
+
+	// a blob is lost in destination
+	delete(r.committed, digest.FromBytes([]byte("chart")))
+	task.stats.DestinationVerified = false
+	assert.ErrorContains(t, task.verifyDestination(resolved), "blob not found")
+	assert.False(t, task.Stats().DestinationVerified)
+
+	// destination serves another manifest
+	r.manifests["dst/1.0.0"] = artifact(t, r, helmConfigMediaType, []byte("another chart"), "application/vnd.cncf.helm.chart.content.v1.tar+gzip", "")
+	assert.ErrorContains(t, task.verifyDestination(resolved), "expected "+digest.FromBytes(chart).String())
+}
+
+func TestVerifyManifestList(t *testing.T) {
+	r := newFakeRegistry(t)
+	task := newFakeTask(r)
+	task.destination.tag = "latest"
+	r.manifests["dst/latest"] = []byte(ociIndex())
+	r.manifests["dst/"+digest.FromString(ociManifestAmd64).String()] = []byte(ociManifestAmd64)
+	task.stats.DestinationDigest = digest.FromString(ociIndex()).String()
+
+	// the manifest of linux/arm64/v8 is not pushed
+	err := task.verifyManifests()
+	assert.ErrorContains(t, err, "of linux/arm64/v8")
+
+	r.manifests["dst/"+digest.FromString(ociManifestArm64).String()] = []byte(ociManifestAmd64)
+	assert.ErrorContains(t, task.verifyManifests(), "manifest of linux/arm64/v8: digest "+digest.FromString(ociManifestAmd64).String())
+
+	r.manifests["dst/"+digest.FromString(ociManifestArm64).String()] = []byte(ociManifestArm64)
+	assert.NoError(t, task.verifyManifests())
+}
@@ -0,0 +1,204 @@
+package tools
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// TagFilter tag过滤规则，所有条件同时满足的tag才会同步，为空的条件不做过滤
+type TagFilter struct {
+	// 只同步匹配的tag，支持glob，比如 v*
+	Include []string `json:"include" yaml:"include"`
+	// 不同步匹配的tag，支持glob，比如 *-SNAPSHOT
+	Exclude []string `json:"exclude" yaml:"exclude"`
+	// 只同步匹配正则的tag
+	IncludeRegex string `json:"includeRegex" yaml:"includeRegex"`
+	// 不同步匹配正则的tag
+	ExcludeRegex string `json:"excludeRegex" yaml:"excludeRegex"`
+	// semver 范围，比如 >=1.2.0 <2，不是semver格式的tag不会同步
+	Semver string `json:"semver" yaml:"semver"`
+	// 只同步最近push的N个tag
+	Newest int `json:"newest" yaml:"newest"`
+	// 只同步最近N天内push的tag
+	WithinDays int `json:"withinDays" yaml:"withinDays"`
+}
+
+// RepoTagFilter 单个repo的tag过滤规则，会覆盖namespace的规则
+type RepoTagFilter struct {
+	// repo名称，支持glob
+	Repo      string `json:"repo" yaml:"repo"`
+	TagFilter `yaml:",inline"`
+}
+
+// TagInfo 一个tag的信息
+type TagInfo struct {
+	Tag    string
+	Digest string
+	Update time.Time
+}
+
+// TagSelector 编译好的 TagFilter
+type TagSelector struct {
+	filter       TagFilter
+	includeRegex *regexp.Regexp
+	excludeRegex *regexp.Regexp
+	constraint   *semver.Constraints
+}
+
+// NewTagSelector 检查并编译tag过滤规则
+func NewTagSelector(filter TagFilter) (*TagSelector, error) {
+	selector := &TagSelector{filter: filter}
+	for _, pattern := range append(append([]string{}, filter.Include...), filter.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %v", pattern, err)
+		}
+	}
+
+	var err error
+	if filter.IncludeRegex != "" {
+		if selector.includeRegex, err = regexp.Compile(filter.IncludeRegex); err != nil {
+			return nil, fmt.Errorf("invalid includeRegex %q: %v", filter.IncludeRegex, err)
+		}
+	}
+	if filter.ExcludeRegex != "" {
+		if selector.excludeRegex, err = regexp.Compile(filter.ExcludeRegex); err != nil {
+			return nil, fmt.Errorf("invalid excludeRegex %q: %v", filter.ExcludeRegex, err)
+		}
+	}
+	if filter.Semver != "" {
+		if selector.constraint, err = semver.NewConstraint(filter.Semver); err != nil {
+			return nil, fmt.Errorf("invalid semver %q: %v", filter.Semver, err)
+		}
+	}
+	if filter.Newest < 0 || filter.WithinDays < 0 {
+		return nil, fmt.Errorf("newest and withinDays should not be negative")
+	}
+	return selector, nil
+}
+
+// Select 返回满足过滤规则的tag，newest 在其它条件过滤之后生效
+func (s *TagSelector) Select(tags []TagInfo, now time.Time) []TagInfo {
+	var selected []TagInfo
+	for _, tag := range tags {
+		if s.match(tag, now) {
+			selected = append(selected, tag)
+		}
+	}
+
+	if s.filter.Newest > 0 && len(selected) > s.filter.Newest {
+		sort.SliceStable(selected, func(i, j int) bool {
+			return selected[i].Update.After(selected[j].Update)
+		})
+		selected = selected[:s.filter.Newest]
+	}
+	return selected
+}
+
+func (s *TagSelector) match(tag TagInfo, now time.Time) bool {
+	if !s.matchName(tag.Tag) {
+		return false
+	}
+	if s.filter.WithinDays > 0 && now.Sub(tag.Update) > time.Duration(s.filter.WithinDays)*24*time.Hour {
+		return false
+	}
+	return true
+}
+
+// matchName 只按tag名称检查 include/exclude/regex/semver
+func (s *TagSelector) matchName(tag string) bool {
+	if len(s.filter.Include) != 0 && !globMatch(s.filter.Include, tag) {
+		return false
+	}
+	if globMatch(s.filter.Exclude, tag) {
+		return false
+	}
+	if s.includeRegex != nil && !s.includeRegex.MatchString(tag) {
+		return false
+	}
+	if s.excludeRegex != nil && s.excludeRegex.MatchString(tag) {
+		return false
+	}
+	if s.constraint != nil {
+		version, err := semver.NewVersion(tag)
+		if err != nil || !s.constraint.Check(version) {
+			return false
+		}
+	}
+	return true
+}
+
+func globMatch(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RepoTagSelector 按repo选择tag过滤规则，先匹配repo规则，没有匹配上使用namespace规则
+type RepoTagSelector struct {
+	defaultSelector *TagSelector
+	repos           []string
+	selectors       []*TagSelector
+}
+
+// NewRepoTagSelector 检查并编译一个namespace的tag过滤规则，defaultFilter 为空时不过滤
+func NewRepoTagSelector(defaultFilter *TagFilter, repoFilters []RepoTagFilter) (*RepoTagSelector, error) {
+	selector := &RepoTagSelector{}
+	var err error
+	if defaultFilter != nil {
+		if selector.defaultSelector, err = NewTagSelector(*defaultFilter); err != nil {
+			return nil, fmt.Errorf("tags: %v", err)
+		}
+	}
+	for i, repoFilter := range repoFilters {
+		if repoFilter.Repo == "" {
+			return nil, fmt.Errorf("repos[%d].repo is required", i)
+		}
+		if _, err := path.Match(repoFilter.Repo, ""); err != nil {
+			return nil, fmt.Errorf("repos[%d]: invalid glob %q: %v", i, repoFilter.Repo, err)
+		}
+		tagSelector, err := NewTagSelector(repoFilter.TagFilter)
+		if err != nil {
+			return nil, fmt.Errorf("repos[%d]: %v", i, err)
+		}
+		selector.repos = append(selector.repos, repoFilter.Repo)
+		selector.selectors = append(selector.selectors, tagSelector)
+	}
+	return selector, nil
+}
+
+// Select 返回repo中满足过滤规则的tag
+func (r *RepoTagSelector) Select(repo string, tags []TagInfo, now time.Time) []TagInfo {
+	if selector := r.selector(repo); selector != nil {
+		return selector.Select(tags, now)
+	}
+	return tags
+}
+
+// MatchName 只按tag名称检查repo的 include/exclude/regex/semver 规则，newest 和 withinDays 需要完整的tag列表，这里不检查
+func (r *RepoTagSelector) MatchName(repo, tag string) bool {
+	if selector := r.selector(repo); selector != nil {
+		return selector.matchName(tag)
+	}
+	return true
+}
+
+// selector 返回repo使用的过滤规则，没有规则时返回nil
+func (r *RepoTagSelector) selector(repo string) *TagSelector {
+	if r == nil {
+		return nil
+	}
+	for i, pattern := range r.repos {
+		if ok, _ := path.Match(pattern, repo); ok {
+			return r.selectors[i]
+		}
+	}
+	return r.defaultSelector
+}
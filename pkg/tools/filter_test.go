@@ -0,0 +1,74 @@
+package tools
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tagNames(tags []TagInfo) []string {
+	var names []string
+	for _, tag := range tags {
+		names = append(names, tag.Tag)
+	}
+	return names
+}
+
+func TestTagSelector(t *testing.T) {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	tags := []TagInfo{
+		{Tag: "v1.1.0", Update: now.Add(-72 * time.Hour)},
+		{Tag: "v1.2.0", Update: now.Add(-48 * time.Hour)},
+		{Tag: "v1.3.0-SNAPSHOT", Update: now.Add(-36 * time.Hour)},
+		{Tag: "v1.3.0", Update: now.Add(-24 * time.Hour)},
+		{Tag: "v2.0.0", Update: now.Add(-1 * time.Hour)},
+		{Tag: "feature-login", Update: now},
+	}
+
+	cases := []struct {
+		filter TagFilter
+		target []string
+	}{
+		{TagFilter{}, []string{"v1.1.0", "v1.2.0", "v1.3.0-SNAPSHOT", "v1.3.0", "v2.0.0", "feature-login"}},
+		{TagFilter{Include: []string{"v*"}, Exclude: []string{"*-SNAPSHOT"}}, []string{"v1.1.0", "v1.2.0", "v1.3.0", "v2.0.0"}},
+		{TagFilter{ExcludeRegex: `^feature-`}, []string{"v1.1.0", "v1.2.0", "v1.3.0-SNAPSHOT", "v1.3.0", "v2.0.0"}},
+		{TagFilter{Semver: ">=1.2.0 <2"}, []string{"v1.2.0", "v1.3.0"}},
+		{TagFilter{Include: []string{"v*"}, Newest: 2}, []string{"v2.0.0", "v1.3.0"}},
+		{TagFilter{WithinDays: 2}, []string{"v1.2.0", "v1.3.0-SNAPSHOT", "v1.3.0", "v2.0.0", "feature-login"}},
+	}
+
+	for _, row := range cases {
+		selector, err := NewTagSelector(row.filter)
+		if err != nil {
+			t.Fatalf("new selector %+v fail: %v", row.filter, err)
+		}
+		assert.Equal(t, row.target, tagNames(selector.Select(tags, now)), "filter %+v", row.filter)
+	}
+}
+
+func TestRepoTagSelector(t *testing.T) {
+	now := time.Now()
+	tags := []TagInfo{{Tag: "v1.0.0", Update: now}, {Tag: "latest", Update: now}}
+
+	selector, err := NewRepoTagSelector(&TagFilter{Include: []string{"v*"}}, []RepoTagFilter{
+		{Repo: "base-*", TagFilter: TagFilter{Include: []string{"latest"}}},
+	})
+	if err != nil {
+		t.Fatalf("new selector fail: %v", err)
+	}
+
+	assert.Equal(t, []string{"latest"}, tagNames(selector.Select("base-alpine", tags, now)))
+	assert.Equal(t, []string{"v1.0.0"}, tagNames(selector.Select("api", tags, now)))
+
+	var empty *RepoTagSelector
+	assert.Equal(t, tags, empty.Select("api", tags, now))
+
+	assert.True(t, selector.MatchName("api", "v1.0.0"))
+	assert.False(t, selector.MatchName("api", "latest"))
+	assert.True(t, selector.MatchName("base-alpine", "latest"))
+	assert.True(t, empty.MatchName("api", "latest"))
+
+	_, err = NewRepoTagSelector(&TagFilter{Semver: ">=one.two"}, nil)
+	assert.NotNil(t, err)
+}
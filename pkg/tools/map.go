@@ -1,40 +1,92 @@
 package tools
 
-import (
-	"reflect"
-)
-
 const (
-	FailFermi = "fail-fermi"
+	// DiffMissing master 有 slave 无的 repo:tag
+	DiffMissing = "missing"
+	// DiffRepoMissing slave 上整个 repo 都不存在
+	DiffRepoMissing = "repo missing"
+	// DiffDigestDrift master 和 slave 都有同名 tag，但是 digest 不一致，比如 latest 这种会被重复 push 的 tag
+	DiffDigestDrift = "digest drift"
+	// DiffTargeted 通过定向同步指定的 repo:tag 或者 repo，不做对比直接同步
+	DiffTargeted = "targeted"
 )
 
-// RepoTagsMapDiff 对比主从镜像最新tag是否一致
-// master 有 salve 无，则需要加入sync map
-// master 有 salve 但不等，则需要加入sync map
-// master 有tag是失败的预定字段fail-fermi，则直接跳过，期待下一次循环可以正常 :)
-func RepoTagsMapDiff(master, slave map[string]string) map[string]string {
-	// 完全相同，表示相安无事，无需同步
-	if reflect.DeepEqual(master, slave) {
-		return nil
+// RepoTags 一个repo拉取tag的结果
+// Err 不为空表示拉取失败，Tags 为空且 Err 为空表示repo没有tag
+type RepoTags struct {
+	Repo string
+	Tags []TagInfo
+	Err  error
+}
+
+// Failed 拉取tag是否失败
+func (r *RepoTags) Failed() bool {
+	return r.Err != nil
+}
+
+// RepoTagsMap repo => 拉取结果
+type RepoTagsMap map[string]*RepoTags
+
+// Failures 拉取失败的repo => 失败原因
+func (m RepoTagsMap) Failures() map[string]string {
+	failures := make(map[string]string)
+	for repo, repoTags := range m {
+		if repoTags.Failed() {
+			failures[repo] = repoTags.Err.Error()
+		}
 	}
+	return failures
+}
 
+// Digest 查询 repo:tag 的digest，没有这个tag时返回空
+func (m RepoTagsMap) Digest(repo, tag string) string {
+	if repoTags, ok := m[repo]; ok {
+		for _, info := range repoTags.Tags {
+			if info.Tag == tag {
+				return info.Digest
+			}
+		}
+	}
+	return ""
+}
+
+// RepoTagsMapDiff 对比主从镜像每个repo的tag，返回 repo:tag => 需要同步的原因
+// master 有 salve 无，则需要加入sync map，原因为 missing，slave 上整个repo都没有时原因为 repo missing
+// master 有 salve 但 digest 不等，则需要加入sync map，原因为 digest drift
+// master 或者 slave 拉取tag失败的repo直接跳过，不知道另一边的真实情况，期待下一次循环可以正常 :)
+func RepoTagsMapDiff(master, slave RepoTagsMap) map[string]string {
 	mapDiff := make(map[string]string)
-	for index, row := range master {
-		// 失败标志直接跳过
-		if row == FailFermi {
+	for repo, masterTags := range master {
+		if masterTags.Failed() {
+			continue
+		}
+		slaveTags, ok := slave[repo]
+		if !ok {
+			for _, tag := range masterTags.Tags {
+				mapDiff[repo+":"+tag.Tag] = DiffRepoMissing
+			}
+			continue
+		}
+		if slaveTags.Failed() {
 			continue
 		}
-		// 存在，则要判断tag是否相等
-		if _, ok := slave[index]; ok {
-			// 相等或者是失败的tag标识，则跳到下一轮
-			if row == slave[index] {
-				continue
+
+		slaveDigests := make(map[string]string, len(slaveTags.Tags))
+		for _, tag := range slaveTags.Tags {
+			slaveDigests[tag.Tag] = tag.Digest
+		}
+		for _, tag := range masterTags.Tags {
+			// 存在，则要判断digest是否相等
+			if slaveDigest, ok := slaveDigests[tag.Tag]; ok {
+				// 相等则跳到下一轮，同名tag内容被重新push过的，需要重新同步
+				if tag.Digest == slaveDigest {
+					continue
+				}
+				mapDiff[repo+":"+tag.Tag] = DiffDigestDrift
 			} else {
-				mapDiff[index] = row
+				// 不存在，则直接加入待sync map
+				mapDiff[repo+":"+tag.Tag] = DiffMissing
 			}
-		} else {
-			// 不存在，则直接加入待sync map
-			mapDiff[index] = row
 		}
 	}
 
@@ -1,122 +1,178 @@
 package tools
 
 import (
+	"errors"
 	"reflect"
 	"strings"
 	"testing"
 )
 
 type RepoTagsMapDiffCase struct {
-	Cases []*RepoTagsMap
+	Cases []*RepoTagsMapCase
 }
 
-type RepoTagsMap struct {
+type RepoTagsMapCase struct {
 	Master map[string]string
 	Slave  map[string]string
-	Target map[string]string
+	// 拉取tag失败的repo
+	MasterFailed []string
+	SlaveFailed  []string
+	Target       map[string]string
+}
+
+// toRepoTagsMap 把 repo:tag => digest 转换成拉取结果，只有repo名称的key表示没有tag的repo
+func toRepoTagsMap(tags map[string]string, failed []string) RepoTagsMap {
+	m := make(RepoTagsMap)
+	for key, digest := range tags {
+		repoAndTag := strings.SplitN(key, ":", 2)
+		repoTags, ok := m[repoAndTag[0]]
+		if !ok {
+			repoTags = &RepoTags{Repo: repoAndTag[0]}
+			m[repoAndTag[0]] = repoTags
+		}
+		if len(repoAndTag) == 2 {
+			repoTags.Tags = append(repoTags.Tags, TagInfo{Tag: repoAndTag[1], Digest: digest})
+		}
+	}
+	for _, repo := range failed {
+		m[repo] = &RepoTags{Repo: repo, Err: errors.New("list repo tags failed")}
+	}
+	return m
 }
 
 func TestRepoTagsMapDiff(t *testing.T) {
 	cases := &RepoTagsMapDiffCase{
-		Cases: []*RepoTagsMap{
+		Cases: []*RepoTagsMapCase{
 			{ // master 和 salve 完全相同
 				Master: map[string]string{
-					"alix:v0.0.1":                 "v0.0.1",
-					"aliyun-images-syncer:v0.0.8": "v0.0.8",
-					"pivot:v3.0.5-alpha.2":        "v3.0.5-alpha.2",
+					"alix:v0.0.1":                 "sha256:a1",
+					"aliyun-images-syncer:v0.0.8": "sha256:b8",
+					"pivot:v3.0.5-alpha.2":        "sha256:c2",
 				},
 				Slave: map[string]string{
-					"alix:v0.0.1":                 "v0.0.1",
-					"aliyun-images-syncer:v0.0.8": "v0.0.8",
-					"pivot:v3.0.5-alpha.2":        "v3.0.5-alpha.2",
+					"alix:v0.0.1":                 "sha256:a1",
+					"aliyun-images-syncer:v0.0.8": "sha256:b8",
+					"pivot:v3.0.5-alpha.2":        "sha256:c2",
 				},
 				Target: (map[string]string{}),
 			},
 			{ // master 和 salve 对应上
 				Master: map[string]string{
-					"alix:v0.0.1":                 "v0.0.1",
-					"aliyun-images-syncer:v0.0.8": "v0.0.8",
-					"pivot:v3.0.5-alpha.2":        "v3.0.5-alpha.2",
+					"alix:v0.0.1":                 "sha256:a1",
+					"aliyun-images-syncer:v0.0.8": "sha256:b8",
+					"pivot:v3.0.5-alpha.2":        "sha256:c2",
 				},
 				Slave: map[string]string{
-					"alix:v0.0.1":                 "v0.0.1",
-					"aliyun-images-syncer:v0.0.7": "v0.0.7",
-					"pivot:v3.0.5-alpha.1":        "v3.0.5-alpha.1",
+					"alix:v0.0.1":                 "sha256:a1",
+					"aliyun-images-syncer:v0.0.7": "sha256:b7",
+					"pivot:v3.0.5-alpha.1":        "sha256:c1",
 				},
 				Target: map[string]string{
-					"aliyun-images-syncer:v0.0.8": "v0.0.8",
-					"pivot:v3.0.5-alpha.2":        "v3.0.5-alpha.2",
+					"aliyun-images-syncer:v0.0.8": DiffMissing,
+					"pivot:v3.0.5-alpha.2":        DiffMissing,
 				},
 			}, { // master 比 slave 多的情况
 				Master: map[string]string{
-					"alix:v0.0.1":                 "v0.0.1",
-					"aliyun-images-syncer:v0.0.8": "v0.0.8",
-					"pivot:v3.0.5-alpha.2":        "v3.0.5-alpha.2",
+					"alix:v0.0.1":                 "sha256:a1",
+					"aliyun-images-syncer:v0.0.8": "sha256:b8",
+					"pivot:v3.0.5-alpha.2":        "sha256:c2",
 				},
 				Slave: map[string]string{
-					"alix:v0.0.1": "v0.0.1",
+					"alix:v0.0.1": "sha256:a1",
 				},
 				Target: map[string]string{
-					"aliyun-images-syncer:v0.0.8": "v0.0.8",
-					"pivot:v3.0.5-alpha.2":        "v3.0.5-alpha.2",
+					"aliyun-images-syncer:v0.0.8": DiffRepoMissing,
+					"pivot:v3.0.5-alpha.2":        DiffRepoMissing,
 				},
 			}, { // salve 没有数据的情况
 				Master: map[string]string{
-					"alix:v0.0.1":                 "v0.0.1",
-					"aliyun-images-syncer:v0.0.8": "v0.0.8",
-					"pivot:v3.0.5-alpha.2":        "v3.0.5-alpha.2",
+					"alix:v0.0.1":                 "sha256:a1",
+					"aliyun-images-syncer:v0.0.8": "sha256:b8",
+					"pivot:v3.0.5-alpha.2":        "sha256:c2",
 				},
 				Slave: map[string]string{},
 				Target: map[string]string{
-					"alix:v0.0.1":                 "v0.0.1",
-					"aliyun-images-syncer:v0.0.8": "v0.0.8",
-					"pivot:v3.0.5-alpha.2":        "v3.0.5-alpha.2",
+					"alix:v0.0.1":                 DiffRepoMissing,
+					"aliyun-images-syncer:v0.0.8": DiffRepoMissing,
+					"pivot:v3.0.5-alpha.2":        DiffRepoMissing,
 				},
 			}, { // case master 比 salve 镜像少的情况
 				Master: map[string]string{
-					"alix:v0.0.1": "v0.0.1",
+					"alix:v0.0.1": "sha256:a1",
 				},
 				Slave: map[string]string{
-					"alix:v0.0.1":                 "v0.0.1",
-					"aliyun-images-syncer:v0.0.8": "v0.0.8",
-					"pivot:v3.0.5-alpha.2":        "v3.0.5-alpha.2",
+					"alix:v0.0.1":                 "sha256:a1",
+					"aliyun-images-syncer:v0.0.8": "sha256:b8",
+					"pivot:v3.0.5-alpha.2":        "sha256:c2",
 				},
 				Target: map[string]string{},
 			}, { // case tag 完全不同类型的情况
 				Master: map[string]string{
-					"alix:v0.0.1":          "v0.0.1",
-					"agent:vagt3.6.0-rc.4": "vagt3.6.0-rc.4",
+					"alix:v0.0.1":          "sha256:a1",
+					"agent:vagt3.6.0-rc.4": "sha256:d4",
+				},
+				Slave: map[string]string{
+					"job:v0.0.1":                  "sha256:e1",
+					"aliyun-images-syncer:v0.0.8": "sha256:b8",
+					"pivot:v3.0.5-alpha.2":        "sha256:c2",
+					"agent:alpha3.6.3":            "sha256:d3",
+				},
+				Target: map[string]string{
+					"alix:v0.0.1":          DiffRepoMissing,
+					"agent:vagt3.6.0-rc.4": DiffMissing,
+				},
+			}, { // case 同名tag被重新push，digest不一致的情况
+				Master: map[string]string{
+					"alix:latest":  "sha256:a2",
+					"alix:release": "sha256:r1",
 				},
 				Slave: map[string]string{
-					"job:v0.0.1":                  "v0.0.1",
-					"aliyun-images-syncer:v0.0.8": "v0.0.8",
-					"pivot:v3.0.5-alpha.2":        "v3.0.5-alpha.2",
-					"agent:alpha3.6.3":            "alpha3.6.3",
+					"alix:latest":  "sha256:a1",
+					"alix:release": "sha256:r1",
 				},
 				Target: map[string]string{
-					"alix:v0.0.1":          "v0.0.1",
-					"agent:vagt3.6.0-rc.4": "vagt3.6.0-rc.4",
+					"alix:latest": DiffDigestDrift,
 				},
 			}, { // case 假设master请求tag list 出错的情况
 				Master: map[string]string{
-					"alix:v0.0.1":      "v0.0.1",
-					"agent:fail-fermi": "fail-fermi",
+					"alix:v0.0.1": "sha256:a1",
+				},
+				MasterFailed: []string{"agent"},
+				Slave: map[string]string{
+					"job:v0.0.1":                  "sha256:e1",
+					"aliyun-images-syncer:v0.0.8": "sha256:b8",
+					"pivot:v3.0.5-alpha.2":        "sha256:c2",
+					"agent:alpha3.6.3":            "sha256:d3",
+				},
+				Target: map[string]string{
+					"alix:v0.0.1": DiffRepoMissing,
+				},
+			}, { // case slave请求tag list 出错，不知道slave的情况，跳过
+				Master: map[string]string{
+					"alix:v0.0.1":  "sha256:a1",
+					"agent:v3.6.0": "sha256:d0",
+				},
+				Slave: map[string]string{
+					"agent:v3.6.0": "sha256:d0",
+				},
+				SlaveFailed: []string{"alix"},
+				Target:      map[string]string{},
+			}, { // case slave的repo存在但是没有tag
+				Master: map[string]string{
+					"alix:v0.0.1": "sha256:a1",
 				},
 				Slave: map[string]string{
-					"job:v0.0.1":                  "v0.0.1",
-					"aliyun-images-syncer:v0.0.8": "v0.0.8",
-					"pivot:v3.0.5-alpha.2":        "v3.0.5-alpha.2",
-					"agent:alpha3.6.3":            "alpha3.6.3",
+					"alix": "",
 				},
 				Target: map[string]string{
-					"alix:v0.0.1": "v0.0.1",
+					"alix:v0.0.1": DiffMissing,
 				},
 			},
 		},
 	}
 
 	for _, row := range cases.Cases {
-		res := RepoTagsMapDiff(row.Master, row.Slave)
+		res := RepoTagsMapDiff(toRepoTagsMap(row.Master, row.MasterFailed), toRepoTagsMap(row.Slave, row.SlaveFailed))
 		if len(row.Target) == 0 && len(res) == 0 {
 			// reflect.DeepEqual 需要不能含有无法比较的成员。
 			continue
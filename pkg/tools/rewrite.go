@@ -0,0 +1,97 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RepoRewriteRule repo名称改写规则，prefix/suffix/regex 三选一
+type RepoRewriteRule struct {
+	// 增加前缀，比如 prod-
+	Prefix string `json:"prefix" yaml:"prefix"`
+	// 增加后缀
+	Suffix string `json:"suffix" yaml:"suffix"`
+	// 正则匹配repo名称，匹配上的部分替换为 replace，replace 支持 $1 这种分组引用
+	Regex   string `json:"regex" yaml:"regex"`
+	Replace string `json:"replace" yaml:"replace"`
+}
+
+// RepoRewriter 按顺序执行多个改写规则
+type RepoRewriter struct {
+	rules   []RepoRewriteRule
+	regexps []*regexp.Regexp
+}
+
+// NewRepoRewriter 检查并编译改写规则
+func NewRepoRewriter(rules []RepoRewriteRule) (*RepoRewriter, error) {
+	rewriter := &RepoRewriter{rules: rules, regexps: make([]*regexp.Regexp, len(rules))}
+	for i, rule := range rules {
+		set := 0
+		for _, v := range []string{rule.Prefix, rule.Suffix, rule.Regex} {
+			if v != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			return nil, fmt.Errorf("rewrites[%d]: exactly one of prefix/suffix/regex should be set", i)
+		}
+		if rule.Replace != "" && rule.Regex == "" {
+			return nil, fmt.Errorf("rewrites[%d]: replace can only be used with regex", i)
+		}
+		if rule.Regex != "" {
+			re, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("rewrites[%d]: invalid regex %q: %v", i, rule.Regex, err)
+			}
+			rewriter.regexps[i] = re
+		}
+	}
+	return rewriter, nil
+}
+
+// Rewrite 改写repo名称
+func (r *RepoRewriter) Rewrite(repo string) string {
+	for i, rule := range r.rules {
+		switch {
+		case rule.Prefix != "":
+			repo = rule.Prefix + repo
+		case rule.Suffix != "":
+			repo = repo + rule.Suffix
+		default:
+			repo = r.regexps[i].ReplaceAllString(repo, rule.Replace)
+		}
+	}
+	return repo
+}
+
+// RewriteRepoTagsMap 把主镜像仓库的repo改写成从镜像仓库的repo名称，
+// 返回改写后的map，以及改写后的repo => 原始repo，方便同步的时候找到主镜像仓库的镜像
+// 多个repo改写成同一个名称会导致同步的目标冲突，这些repo都不同步，和冲突的名称一起返回错误
+func RewriteRepoTagsMap(repoTags RepoTagsMap, rewriter *RepoRewriter) (RepoTagsMap, map[string]string, error) {
+	// 改写后的repo => 所有的原始repo
+	origins := make(map[string][]string, len(repoTags))
+	for repo := range repoTags {
+		newRepo := rewriter.Rewrite(repo)
+		origins[newRepo] = append(origins[newRepo], repo)
+	}
+
+	rewritten := make(RepoTagsMap, len(origins))
+	sources := make(map[string]string, len(origins))
+	var errs []error
+	for newRepo, repos := range origins {
+		if len(repos) > 1 {
+			sort.Strings(repos)
+			errs = append(errs, fmt.Errorf("repo %s are all rewritten to %s, none of them is synced", strings.Join(repos, ", "), newRepo))
+			continue
+		}
+		tags := repoTags[repos[0]]
+		rewritten[newRepo] = &RepoTags{Repo: newRepo, Tags: tags.Tags, Err: tags.Err}
+		sources[newRepo] = repos[0]
+	}
+	// 错误信息的顺序固定，方便对比每一轮的报告
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return rewritten, sources, errors.Join(errs...)
+}
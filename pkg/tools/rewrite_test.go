@@ -0,0 +1,67 @@
+package tools
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepoRewriter(t *testing.T) {
+	rewriter, err := NewRepoRewriter([]RepoRewriteRule{
+		{Regex: `^dev-(.*)$`, Replace: "$1"},
+		{Prefix: "prod-"},
+		{Suffix: "-release"},
+	})
+	if err != nil {
+		t.Fatalf("new rewriter fail: %v", err)
+	}
+
+	assert.Equal(t, "prod-api-release", rewriter.Rewrite("dev-api"))
+	assert.Equal(t, "prod-web-release", rewriter.Rewrite("web"))
+
+	empty, _ := NewRepoRewriter(nil)
+	assert.Equal(t, "web", empty.Rewrite("web"))
+}
+
+func TestNewRepoRewriterInvalid(t *testing.T) {
+	for _, rules := range [][]RepoRewriteRule{
+		{{}},
+		{{Prefix: "a", Suffix: "b"}},
+		{{Prefix: "a", Replace: "b"}},
+		{{Regex: "("}},
+	} {
+		if _, err := NewRepoRewriter(rules); err == nil {
+			t.Errorf("rules %v should be invalid", rules)
+		}
+	}
+}
+
+func TestRewriteRepoTagsMap(t *testing.T) {
+	rewriter, _ := NewRepoRewriter([]RepoRewriteRule{{Prefix: "prod-"}})
+	listErr := errors.New("list repo tags failed")
+	rewritten, sources, err := RewriteRepoTagsMap(RepoTagsMap{
+		"api": {Repo: "api", Tags: []TagInfo{{Tag: "v1", Digest: "sha256:a1"}}},
+		"web": {Repo: "web", Err: listErr},
+	}, rewriter)
+
+	assert.Nil(t, err)
+	assert.Equal(t, RepoTagsMap{
+		"prod-api": {Repo: "prod-api", Tags: []TagInfo{{Tag: "v1", Digest: "sha256:a1"}}},
+		"prod-web": {Repo: "prod-web", Err: listErr},
+	}, rewritten)
+	assert.Equal(t, "api", sources["prod-api"])
+
+	// 两个repo改写成同一个名称
+	rewriter, _ = NewRepoRewriter([]RepoRewriteRule{{Regex: `-v\d+$`}})
+	rewritten, _, err = RewriteRepoTagsMap(RepoTagsMap{
+		"api-v1": {Repo: "api-v1", Tags: []TagInfo{{Tag: "latest", Digest: "sha256:a1"}}},
+		"api-v2": {Repo: "api-v2", Tags: []TagInfo{{Tag: "latest", Digest: "sha256:a2"}}},
+	}, rewriter)
+	assert.EqualError(t, err, "repo api-v1, api-v2 are all rewritten to api, none of them is synced")
+	// 冲突的repo都不同步，避免每一轮随机同步其中一个
+	assert.NotContains(t, rewritten, "api")
+	assert.NotContains(t, rewritten, "api-v1")
+	assert.NotContains(t, rewritten, "api-v2")
+	assert.Empty(t, rewritten)
+}